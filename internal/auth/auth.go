@@ -0,0 +1,152 @@
+// Package auth implements pluggable SOCKS5 authentication methods, selected
+// during the version/method negotiation step of the SOCKS5 handshake (RFC
+// 1928 section 3).
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/tomventa/wirebalancer/internal/config"
+)
+
+const (
+	MethodNoAuth       = 0x00
+	MethodGSSAPI       = 0x01
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xFF
+
+	userPassVersion = 0x01
+	userPassSuccess = 0x00
+	userPassFailure = 0x01
+)
+
+// AuthContext carries the outcome of a successful Authenticator.Authenticate
+// call. It's passed on to connection selection so operators can pin a given
+// user's traffic to a specific WireGuard tunnel.
+type AuthContext struct {
+	// Username identifies the authenticated client. Empty for methods that
+	// don't identify one, such as NoAuthAuthenticator.
+	Username string
+}
+
+// Authenticator implements one SOCKS5 authentication method: it advertises
+// its METHODS byte during negotiation and, once selected, runs its
+// method-specific subnegotiation against the client connection.
+type Authenticator interface {
+	// Method returns the SOCKS5 METHODS byte this authenticator handles.
+	Method() byte
+	// Authenticate runs the method's subnegotiation against conn. It
+	// returns the resulting AuthContext, or an error if authentication
+	// fails.
+	Authenticate(conn net.Conn) (*AuthContext, error)
+}
+
+// BuildAuthenticators returns the Authenticators enabled by cfg, in the
+// order they should be offered to clients during negotiation. With no users
+// configured, no-auth is the only method enabled, matching the proxy's
+// historical zero-config behavior.
+func BuildAuthenticators(cfg config.AuthConfig) []Authenticator {
+	if len(cfg.Users) == 0 {
+		return []Authenticator{NewNoAuthAuthenticator()}
+	}
+
+	authenticators := []Authenticator{NewUserPassAuthenticator(cfg.Users)}
+	if cfg.AllowGSSAPI {
+		authenticators = append(authenticators, NewGSSAPIAuthenticator())
+	}
+	if cfg.AllowNoAuth {
+		authenticators = append(authenticators, NewNoAuthAuthenticator())
+	}
+	return authenticators
+}
+
+// NoAuthAuthenticator implements the SOCKS5 "no authentication required"
+// method (0x00): authentication succeeds unconditionally once selected.
+type NoAuthAuthenticator struct{}
+
+func NewNoAuthAuthenticator() *NoAuthAuthenticator {
+	return &NoAuthAuthenticator{}
+}
+
+func (a *NoAuthAuthenticator) Method() byte { return MethodNoAuth }
+
+func (a *NoAuthAuthenticator) Authenticate(conn net.Conn) (*AuthContext, error) {
+	return &AuthContext{}, nil
+}
+
+// GSSAPIAuthenticator advertises the GSSAPI method (0x01, RFC 1961) so
+// clients that require it don't fall back to an unsupported-method error
+// during negotiation, but it does not implement Kerberos token exchange:
+// Authenticate always fails. It exists as a negotiation stub for clients
+// that offer GSSAPI before other methods, not as a working implementation.
+type GSSAPIAuthenticator struct{}
+
+func NewGSSAPIAuthenticator() *GSSAPIAuthenticator {
+	return &GSSAPIAuthenticator{}
+}
+
+func (a *GSSAPIAuthenticator) Method() byte { return MethodGSSAPI }
+
+func (a *GSSAPIAuthenticator) Authenticate(conn net.Conn) (*AuthContext, error) {
+	return nil, errors.New("GSSAPI authentication is not implemented")
+}
+
+// UserPassAuthenticator implements RFC 1929 username/password
+// authentication (method 0x02) against a fixed set of credentials.
+type UserPassAuthenticator struct {
+	users map[string]string // username -> password
+}
+
+func NewUserPassAuthenticator(users map[string]string) *UserPassAuthenticator {
+	return &UserPassAuthenticator{users: users}
+}
+
+func (a *UserPassAuthenticator) Method() byte { return MethodUserPass }
+
+func (a *UserPassAuthenticator) Authenticate(conn net.Conn) (*AuthContext, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("read userpass header: %w", err)
+	}
+	if header[0] != userPassVersion {
+		return nil, fmt.Errorf("unsupported userpass subnegotiation version: %d", header[0])
+	}
+
+	username, err := readCountedField(conn, int(header[1]))
+	if err != nil {
+		return nil, fmt.Errorf("read username: %w", err)
+	}
+
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return nil, fmt.Errorf("read password length: %w", err)
+	}
+	password, err := readCountedField(conn, int(plen[0]))
+	if err != nil {
+		return nil, fmt.Errorf("read password: %w", err)
+	}
+
+	expected, ok := a.users[username]
+	valid := ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+	if !valid {
+		conn.Write([]byte{userPassVersion, userPassFailure})
+		return nil, fmt.Errorf("invalid credentials for user %q", username)
+	}
+
+	if _, err := conn.Write([]byte{userPassVersion, userPassSuccess}); err != nil {
+		return nil, fmt.Errorf("write userpass success: %w", err)
+	}
+	return &AuthContext{Username: username}, nil
+}
+
+func readCountedField(conn net.Conn, n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/tomventa/wirebalancer/internal/config"
+)
+
+// authConfig builds a config.AuthConfig for the BuildAuthenticators table
+// tests below.
+func authConfig(users map[string]string, allowNoAuth, allowGSSAPI bool) config.AuthConfig {
+	return config.AuthConfig{
+		Users:       users,
+		AllowNoAuth: allowNoAuth,
+		AllowGSSAPI: allowGSSAPI,
+	}
+}
+
+// rfc1929Request builds a client-side RFC 1929 username/password
+// subnegotiation request: VER ULEN UNAME PLEN PASSWD.
+func rfc1929Request(username, password string) []byte {
+	req := []byte{userPassVersion, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	return req
+}
+
+func TestUserPassAuthenticator_Authenticate(t *testing.T) {
+	tests := []struct {
+		name         string
+		users        map[string]string
+		request      []byte
+		wantUsername string
+		wantErr      bool
+		wantReply    byte
+	}{
+		{
+			name:         "valid credentials",
+			users:        map[string]string{"alice": "hunter2"},
+			request:      rfc1929Request("alice", "hunter2"),
+			wantUsername: "alice",
+			wantReply:    userPassSuccess,
+		},
+		{
+			name:      "bad password",
+			users:     map[string]string{"alice": "hunter2"},
+			request:   rfc1929Request("alice", "wrong"),
+			wantErr:   true,
+			wantReply: userPassFailure,
+		},
+		{
+			name:      "unknown user",
+			users:     map[string]string{"alice": "hunter2"},
+			request:   rfc1929Request("mallory", "hunter2"),
+			wantErr:   true,
+			wantReply: userPassFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+
+			replyc := make(chan []byte, 1)
+			go func() {
+				client.Write(tt.request)
+				// Read the reply, which also keeps Authenticate's final
+				// Write from blocking forever on net.Pipe's unbuffered
+				// rendezvous.
+				reply, _ := io.ReadAll(client)
+				replyc <- reply
+			}()
+
+			authenticator := NewUserPassAuthenticator(tt.users)
+			authCtx, err := authenticator.Authenticate(server)
+			server.Close()
+
+			reply := <-replyc
+			if len(reply) != 2 || reply[0] != userPassVersion || reply[1] != tt.wantReply {
+				t.Errorf("reply = %v, want [%d %d]", reply, userPassVersion, tt.wantReply)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() unexpected error: %v", err)
+			}
+			if authCtx.Username != tt.wantUsername {
+				t.Errorf("Username = %q, want %q", authCtx.Username, tt.wantUsername)
+			}
+		})
+	}
+}
+
+func TestUserPassAuthenticator_Authenticate_WritesReply(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	users := map[string]string{"alice": "hunter2"}
+	go func() {
+		client.Write(rfc1929Request("alice", "wrong"))
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		NewUserPassAuthenticator(users).Authenticate(server)
+		close(done)
+	}()
+
+	reply := make([]byte, 2)
+	if _, err := client.Read(reply); err != nil {
+		t.Fatalf("reading reply: %v", err)
+	}
+	<-done
+
+	if reply[0] != userPassVersion || reply[1] != userPassFailure {
+		t.Errorf("reply = %v, want [%d %d]", reply, userPassVersion, userPassFailure)
+	}
+}
+
+func TestGSSAPIAuthenticator_NotImplemented(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	if _, err := NewGSSAPIAuthenticator().Authenticate(server); err == nil {
+		t.Fatal("Authenticate() error = nil, want error for unimplemented GSSAPI")
+	}
+}
+
+func TestBuildAuthenticators(t *testing.T) {
+	tests := []struct {
+		name        string
+		users       map[string]string
+		allowNoAuth bool
+		allowGSSAPI bool
+		wantMethods []byte
+	}{
+		{
+			name:        "no users configured defaults to no-auth",
+			wantMethods: []byte{MethodNoAuth},
+		},
+		{
+			name:        "users configured without extras requires userpass only",
+			users:       map[string]string{"alice": "hunter2"},
+			wantMethods: []byte{MethodUserPass},
+		},
+		{
+			name:        "users with no-auth and gssapi allowed",
+			users:       map[string]string{"alice": "hunter2"},
+			allowNoAuth: true,
+			allowGSSAPI: true,
+			wantMethods: []byte{MethodUserPass, MethodGSSAPI, MethodNoAuth},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticators := BuildAuthenticators(authConfig(tt.users, tt.allowNoAuth, tt.allowGSSAPI))
+			if len(authenticators) != len(tt.wantMethods) {
+				t.Fatalf("got %d authenticators, want %d", len(authenticators), len(tt.wantMethods))
+			}
+			for i, want := range tt.wantMethods {
+				if got := authenticators[i].Method(); got != want {
+					t.Errorf("authenticators[%d].Method() = %#x, want %#x", i, got, want)
+				}
+			}
+		})
+	}
+}
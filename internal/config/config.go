@@ -14,28 +14,79 @@ type Config struct {
 }
 
 type WireGuardConfig struct {
-	Connections         []ConnectionConfig `yaml:"connections"`
-	HealthCheckURL      string             `yaml:"health_check_url"`
-	HealthCheckInterval int                `yaml:"health_check_interval"` // seconds
-	FailureThreshold    int                `yaml:"failure_threshold"`
+	Connections          []ConnectionConfig `yaml:"connections"`
+	HealthCheckURL       string             `yaml:"health_check_url"`
+	HealthCheckInterval  int                `yaml:"health_check_interval"` // seconds
+	FailureThreshold     int                `yaml:"failure_threshold"`
+	PassiveFailures      int                `yaml:"passive_failures"`       // dial failures within the window before marking unhealthy
+	PassiveFailureWindow int                `yaml:"passive_failure_window"` // seconds
 }
 
 type ConnectionConfig struct {
 	Name          string `yaml:"name"`
 	InterfaceName string `yaml:"interface_name"`
 	ConfigPath    string `yaml:"config_path"`
+	Weight        int    `yaml:"weight"` // relative weight for the "weighted" selection policy, default 1
 }
 
 type ProxyConfig struct {
-	BasePort        int `yaml:"base_port"` // 9930
-	ReadTimeout     int `yaml:"read_timeout"`
-	WriteTimeout    int `yaml:"write_timeout"`
-	FailureHTTPCode int `yaml:"failure_http_code"` // 580
-	BufferSize      int `yaml:"buffer_size"`
+	BasePort        int              `yaml:"base_port"` // 9930
+	ReadTimeout     int              `yaml:"read_timeout"`
+	WriteTimeout    int              `yaml:"write_timeout"`
+	FailureHTTPCode int              `yaml:"failure_http_code"` // 580
+	BufferSize      int              `yaml:"buffer_size"`
+	Listeners       []ListenerConfig `yaml:"listeners"`      // optional, overrides the default base_port listener layout
+	DefaultPolicy   string           `yaml:"default_policy"` // policy used by the default (non-pinned) BasePort listener; "suggest" uses wireguard.Manager.SuggestConnection, empty defaults to "random"
+	Auth            AuthConfig       `yaml:"auth"`
+	// PolicyFile points at a YAML or JSON egress policy rule file (see the
+	// policy package). Empty disables the policy engine: every request is
+	// allowed through the listener's normal selection policy, matching the
+	// proxy's historical behavior.
+	PolicyFile string `yaml:"policy_file"`
+	// SniffTimeout bounds, in milliseconds, how long a CONNECT waits for
+	// enough client bytes to sniff the application protocol (TLS SNI /
+	// HTTP Host) before falling back to the SOCKS-supplied address. 0
+	// defaults to 100ms.
+	SniffTimeout int `yaml:"sniff_timeout"`
+}
+
+// AuthConfig configures SOCKS5 client authentication and, optionally,
+// per-user WireGuard connection pinning.
+type AuthConfig struct {
+	// Users enables RFC 1929 username/password authentication when
+	// non-empty, mapping username to password. An empty Users leaves the
+	// proxy's historical zero-config behavior: no authentication required.
+	Users map[string]string `yaml:"users"`
+	// AllowNoAuth additionally advertises no-auth alongside user/pass.
+	// Ignored when Users is empty, since no-auth is the only method then.
+	AllowNoAuth bool `yaml:"allow_no_auth"`
+	// AllowGSSAPI additionally advertises the GSSAPI stub method. Clients
+	// that select it will fail authentication, since Kerberos token
+	// exchange isn't implemented; this only avoids an unsupported-method
+	// rejection for clients that offer GSSAPI ahead of other methods.
+	AllowGSSAPI bool `yaml:"allow_gssapi"`
+	// ConnectionMap pins an authenticated user's traffic to a specific
+	// WireGuard connection by name, overriding the listener's selection
+	// policy for that user.
+	ConnectionMap map[string]string `yaml:"connection_map"`
+}
+
+// ListenerConfig describes one SOCKS5 listener and the selection policy it
+// uses to pick a WireGuard connection for each request. When Listeners is
+// omitted entirely, the proxy falls back to the historical layout: a
+// listener on BasePort using DefaultPolicy ("random" if unset), plus one
+// pinned listener per WireGuard connection on BasePort+index+1.
+type ListenerConfig struct {
+	Port     int    `yaml:"port"`
+	Policy   string `yaml:"policy"`    // round_robin, random, least_requests, least_latency, weighted, ip_hash
+	PinIndex int    `yaml:"pin_index"` // 1-based index into wireguard.connections; 0 means "use Policy" instead of pinning
 }
 
 type WebServerConfig struct {
 	Port int `yaml:"port"` // 9929
+	// AdminToken gates the /api/admin/* endpoints. When empty, the admin API
+	// is disabled.
+	AdminToken string `yaml:"admin_token"`
 }
 
 func Load(path string) (*Config, error) {
@@ -44,6 +95,13 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("reading config file: %w", err)
 	}
 
+	return Parse(data)
+}
+
+// Parse builds a Config from raw YAML bytes and applies the same defaults as
+// Load. It is used directly by the admin hot-reload endpoint, which receives
+// a new config body over HTTP rather than from disk.
+func Parse(data []byte) (*Config, error) {
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("parsing config file: %w", err)
@@ -61,6 +119,12 @@ func Load(path string) (*Config, error) {
 	if cfg.WireGuard.FailureThreshold == 0 {
 		cfg.WireGuard.FailureThreshold = 3
 	}
+	if cfg.WireGuard.PassiveFailures == 0 {
+		cfg.WireGuard.PassiveFailures = 5
+	}
+	if cfg.WireGuard.PassiveFailureWindow == 0 {
+		cfg.WireGuard.PassiveFailureWindow = 30
+	}
 	if cfg.Proxy.BasePort == 0 {
 		cfg.Proxy.BasePort = 9930
 	}
@@ -76,6 +140,9 @@ func Load(path string) (*Config, error) {
 	if cfg.Proxy.BufferSize == 0 {
 		cfg.Proxy.BufferSize = 32768 // 32KB
 	}
+	if cfg.Proxy.SniffTimeout == 0 {
+		cfg.Proxy.SniffTimeout = 100
+	}
 	if cfg.WebServer.Port == 0 {
 		cfg.WebServer.Port = 9929
 	}
@@ -0,0 +1,365 @@
+// Package policy implements a rule-based egress policy engine. Ordered
+// rules are matched against an incoming SOCKS5 request's source address,
+// authenticated username, and destination, and produce a Decision telling
+// the proxy how to serve (or refuse) it: allow it (optionally pinned to a
+// specific WireGuard connection), redispatch it through an upstream SOCKS5
+// proxy, or deny it outright.
+package policy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	socks5Version = 0x05
+	methodNoAuth  = 0x00
+	cmdConnect    = 0x01
+	atypIPv4      = 0x01
+	atypDomain    = 0x03
+	atypIPv6      = 0x04
+	repSuccess    = 0x00
+)
+
+// Action is the outcome of evaluating a Request against the rule set.
+type Action int
+
+const (
+	// ActionAllow lets the request proceed through the listener's normal
+	// selection policy, or Decision.Connection if a rule pinned one.
+	ActionAllow Action = iota
+	// ActionRedispatch forwards the request through an upstream SOCKS5
+	// proxy (Decision.RedispatchAddr) instead of dialing it directly.
+	ActionRedispatch
+	// ActionDeny refuses the request outright.
+	ActionDeny
+)
+
+// Request carries the request-scoped attributes rules are evaluated
+// against.
+type Request struct {
+	SrcIP    net.IP
+	Username string
+	DstHost  string
+	DstPort  int
+	// Atyp is the SOCKS5 address type of the destination (0x01 IPv4, 0x03
+	// domain, 0x04 IPv6), for rules that care about the distinction.
+	Atyp byte
+}
+
+// Decision is the result of Engine.Evaluate: what to do with a request and,
+// for ActionAllow/ActionRedispatch, the target to do it through.
+type Decision struct {
+	Action Action
+	// Connection names the WireGuard connection to use, set only when a
+	// matching ActionAllow rule pins one. Empty means "use the listener's
+	// selection policy".
+	Connection string
+	// RedispatchAddr is the upstream SOCKS5 proxy address ("host:port"),
+	// set only for ActionRedispatch.
+	RedispatchAddr string
+	// Rule names the rule that produced this decision, for logging. Empty
+	// when no rule matched (the default-allow fallthrough).
+	Rule string
+}
+
+// Rule is one line of the egress policy: a set of match criteria and the
+// action to take when all of them are satisfied. An empty/zero criterion
+// matches anything. Rules are evaluated in file order; the first match
+// wins.
+type Rule struct {
+	Name string `yaml:"name" json:"name"`
+
+	SrcCIDR   string `yaml:"src_cidr" json:"src_cidr"`
+	Username  string `yaml:"username" json:"username"`
+	DstCIDR   string `yaml:"dst_cidr" json:"dst_cidr"`
+	DstSuffix string `yaml:"dst_suffix" json:"dst_suffix"` // matched against the lowercased destination host, e.g. ".example.com"
+	PortStart int    `yaml:"port_start" json:"port_start"` // 0 means unbounded below
+	PortEnd   int    `yaml:"port_end" json:"port_end"`     // 0 means unbounded above
+	TimeStart string `yaml:"time_start" json:"time_start"` // "HH:MM", local time; both empty disables the window check
+	TimeEnd   string `yaml:"time_end" json:"time_end"`
+
+	// Action is one of "allow", "deny", "redispatch".
+	Action string `yaml:"action" json:"action"`
+	// Connection pins an "allow" match to this WireGuard connection by
+	// name, overriding the listener's selection policy.
+	Connection string `yaml:"connection" json:"connection"`
+	// RedispatchAddr is the upstream SOCKS5 proxy ("host:port") a
+	// "redispatch" match is forwarded through.
+	RedispatchAddr string `yaml:"redispatch_addr" json:"redispatch_addr"`
+}
+
+type compiledRule struct {
+	rule   Rule
+	srcNet *net.IPNet
+	dstNet *net.IPNet
+}
+
+// Engine holds a compiled, ordered rule set ready for repeated evaluation.
+type Engine struct {
+	rules []compiledRule
+}
+
+// Load reads a policy rule set from a YAML or JSON file, selecting the
+// parser by extension (".json" for JSON, anything else for YAML), and
+// compiles it into an Engine.
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+
+	var rules []Rule
+	if strings.HasSuffix(strings.ToLower(path), ".json") {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("parsing policy file as JSON: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("parsing policy file as YAML: %w", err)
+		}
+	}
+
+	return NewEngine(rules)
+}
+
+// NewEngine compiles rules into an Engine, validating and pre-parsing each
+// rule's CIDR fields and action.
+func NewEngine(rules []Rule) (*Engine, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		cr := compiledRule{rule: r}
+
+		if r.SrcCIDR != "" {
+			_, ipnet, err := net.ParseCIDR(r.SrcCIDR)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid src_cidr %q: %w", r.Name, r.SrcCIDR, err)
+			}
+			cr.srcNet = ipnet
+		}
+		if r.DstCIDR != "" {
+			_, ipnet, err := net.ParseCIDR(r.DstCIDR)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid dst_cidr %q: %w", r.Name, r.DstCIDR, err)
+			}
+			cr.dstNet = ipnet
+		}
+		switch r.Action {
+		case "allow", "deny", "redispatch":
+		default:
+			return nil, fmt.Errorf("rule %q: unknown action %q", r.Name, r.Action)
+		}
+		if r.Action == "redispatch" && r.RedispatchAddr == "" {
+			return nil, fmt.Errorf("rule %q: redispatch action requires redispatch_addr", r.Name)
+		}
+		if _, err := parseClock(r.TimeStart); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid time_start: %w", r.Name, err)
+		}
+		if _, err := parseClock(r.TimeEnd); err != nil {
+			return nil, fmt.Errorf("rule %q: invalid time_end: %w", r.Name, err)
+		}
+
+		compiled = append(compiled, cr)
+	}
+	return &Engine{rules: compiled}, nil
+}
+
+// Evaluate scans the rules in order and returns the Decision for the first
+// match. If no rule matches, the request is allowed through unpinned.
+func (e *Engine) Evaluate(req *Request) Decision {
+	for _, cr := range e.rules {
+		if !cr.matches(req) {
+			continue
+		}
+		switch cr.rule.Action {
+		case "deny":
+			return Decision{Action: ActionDeny, Rule: cr.rule.Name}
+		case "redispatch":
+			return Decision{Action: ActionRedispatch, RedispatchAddr: cr.rule.RedispatchAddr, Rule: cr.rule.Name}
+		default: // "allow"
+			return Decision{Action: ActionAllow, Connection: cr.rule.Connection, Rule: cr.rule.Name}
+		}
+	}
+	return Decision{Action: ActionAllow}
+}
+
+func (cr *compiledRule) matches(req *Request) bool {
+	r := &cr.rule
+
+	if cr.srcNet != nil && (req.SrcIP == nil || !cr.srcNet.Contains(req.SrcIP)) {
+		return false
+	}
+	if r.Username != "" && r.Username != req.Username {
+		return false
+	}
+	if cr.dstNet != nil {
+		ip := net.ParseIP(req.DstHost)
+		if ip == nil || !cr.dstNet.Contains(ip) {
+			return false
+		}
+	}
+	if r.DstSuffix != "" && !strings.HasSuffix(strings.ToLower(req.DstHost), strings.ToLower(r.DstSuffix)) {
+		return false
+	}
+	if r.PortStart > 0 && req.DstPort < r.PortStart {
+		return false
+	}
+	if r.PortEnd > 0 && req.DstPort > r.PortEnd {
+		return false
+	}
+	if r.TimeStart != "" || r.TimeEnd != "" {
+		if !withinTimeWindow(r.TimeStart, r.TimeEnd, time.Now()) {
+			return false
+		}
+	}
+	return true
+}
+
+// withinTimeWindow reports whether now's local time-of-day falls within
+// [start, end). A window that wraps past midnight (end <= start) is
+// treated as spanning to the next day.
+func withinTimeWindow(start, end string, now time.Time) bool {
+	s, _ := parseClock(start)
+	e, _ := parseClock(end)
+	cur := now.Hour()*60 + now.Minute()
+	if s < e {
+		return cur >= s && cur < e
+	}
+	return cur >= s || cur < e
+}
+
+// parseClock parses an "HH:MM" string into minutes since midnight. An empty
+// string parses as 0 (midnight), so a rule can set just one bound.
+func parseClock(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	h, errH := strconv.Atoi(parts[0])
+	m, errM := strconv.Atoi(parts[1])
+	if errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return h*60 + m, nil
+}
+
+// Redispatch dials an upstream SOCKS5 proxy at proxyAddr and performs a
+// client-side SOCKS5 handshake (RFC 1928) to CONNECT it to req's
+// destination, returning the established connection ready to relay. This
+// lets a "redispatch" rule chain the request through another SOCKS5 daemon
+// (e.g. a corporate filter) instead of dialing the destination directly.
+// The upstream proxy is offered no-auth only; it must accept it.
+func Redispatch(proxyNetwork, proxyAddr string, req *Request) (net.Conn, error) {
+	conn, err := net.DialTimeout(proxyNetwork, proxyAddr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("dial upstream proxy %s: %w", proxyAddr, err)
+	}
+
+	if _, err := conn.Write([]byte{socks5Version, 1, methodNoAuth}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write method negotiation: %w", err)
+	}
+	selected := make([]byte, 2)
+	if _, err := io.ReadFull(conn, selected); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read method selection: %w", err)
+	}
+	if selected[0] != socks5Version || selected[1] != methodNoAuth {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy rejected no-auth method (selected %#x)", selected[1])
+	}
+
+	if err := writeConnectRequest(conn, req.DstHost, req.DstPort); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := readConnectReply(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// writeConnectRequest sends a SOCKS5 CONNECT request (VER CMD RSV ATYP
+// DST.ADDR DST.PORT) for host:port.
+func writeConnectRequest(conn net.Conn, host string, port int) error {
+	atyp := byte(atypDomain)
+	var addrBytes []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			atyp, addrBytes = atypIPv4, ip4
+		} else {
+			atyp, addrBytes = atypIPv6, ip.To16()
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("domain name too long: %d bytes", len(host))
+		}
+		addrBytes = append([]byte{byte(len(host))}, host...)
+	}
+
+	req := make([]byte, 0, 4+len(addrBytes)+2)
+	req = append(req, socks5Version, cmdConnect, 0x00, atyp)
+	req = append(req, addrBytes...)
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(port))
+	req = append(req, portBuf...)
+
+	_, err := conn.Write(req)
+	return err
+}
+
+// readConnectReply reads a SOCKS5 reply (VER REP RSV ATYP BND.ADDR
+// BND.PORT) and returns an error unless REP is success. BND.ADDR/BND.PORT
+// are discarded: callers only need the connection itself.
+func readConnectReply(conn net.Conn) error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("read connect reply: %w", err)
+	}
+	if header[0] != socks5Version {
+		return fmt.Errorf("unexpected reply version: %d", header[0])
+	}
+	if header[1] != repSuccess {
+		return fmt.Errorf("upstream proxy refused CONNECT: reply code %#x", header[1])
+	}
+	if err := discardBoundAddress(conn, header[3]); err != nil {
+		return fmt.Errorf("read bind address: %w", err)
+	}
+	return nil
+}
+
+// discardBoundAddress reads and discards an ATYP-tagged BND.ADDR/BND.PORT
+// pair from r.
+func discardBoundAddress(r io.Reader, atyp byte) error {
+	var n int
+	switch atyp {
+	case atypIPv4:
+		n = 4
+	case atypIPv6:
+		n = 16
+	case atypDomain:
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return err
+		}
+		n = int(lenBuf[0])
+	default:
+		return fmt.Errorf("unsupported address type: %d", atyp)
+	}
+	_, err := io.ReadFull(r, make([]byte, n+2)) // + BND.PORT
+	return err
+}
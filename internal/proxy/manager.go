@@ -1,40 +1,69 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/tomventa/wirebalancer/internal/auth"
 	"github.com/tomventa/wirebalancer/internal/config"
+	"github.com/tomventa/wirebalancer/internal/policy"
+	"github.com/tomventa/wirebalancer/internal/sniffer"
 	"github.com/tomventa/wirebalancer/internal/stats"
 	"github.com/tomventa/wirebalancer/internal/wireguard"
 )
 
 const (
-	socks5Version = 0x05
-	noAuth        = 0x00
-	cmdConnect    = 0x01
-	atypIPv4      = 0x01
-	atypDomain    = 0x03
-	atypIPv6      = 0x04
-	repSuccess    = 0x00
-	repFailure    = 0x01
+	socks5Version          = 0x05
+	cmdConnect             = 0x01
+	cmdBind                = 0x02
+	cmdUDPAssociate        = 0x03
+	atypIPv4               = 0x01
+	atypDomain             = 0x03
+	atypIPv6               = 0x04
+	repSuccess             = 0x00
+	repFailure             = 0x01
+	repRuleDeny            = 0x02
+	repCommandNotSupported = 0x07
+
+	// udpAssociationIdleTimeout bounds how long the UDP relay loop waits
+	// between datagrams before re-checking whether the associated TCP
+	// control connection has closed.
+	udpAssociationIdleTimeout = time.Second
 )
 
 type Manager struct {
-	cfg       config.ProxyConfig
-	wgManager *wireguard.Manager
-	stats     *stats.Tracker
-	bufPool   *sync.Pool
+	cfg            config.ProxyConfig
+	wgManager      *wireguard.Manager
+	stats          *stats.Tracker
+	bufPool        *sync.Pool
+	authenticators []auth.Authenticator
+	policyEngine   *policy.Engine
 }
 
-func NewManager(cfg config.ProxyConfig, wgManager *wireguard.Manager, statsTracker *stats.Tracker) *Manager {
+// NewManager builds a Manager, loading cfg.PolicyFile if one is configured.
+// It fails if the policy file can't be read or parsed.
+func NewManager(cfg config.ProxyConfig, wgManager *wireguard.Manager, statsTracker *stats.Tracker) (*Manager, error) {
+	policyEngine, err := policy.NewEngine(nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PolicyFile != "" {
+		policyEngine, err = policy.Load(cfg.PolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading policy file: %w", err)
+		}
+	}
+
 	return &Manager{
 		cfg:       cfg,
 		wgManager: wgManager,
@@ -45,10 +74,18 @@ func NewManager(cfg config.ProxyConfig, wgManager *wireguard.Manager, statsTrack
 				return &buf
 			},
 		},
-	}
+		authenticators: auth.BuildAuthenticators(cfg.Auth),
+		policyEngine:   policyEngine,
+	}, nil
 }
 
-func (m *Manager) StartProxy(ctx context.Context, index int, port int) error {
+// ConnectionSelector picks the WireGuard connection that should serve a
+// request arriving from clientAddr. It is how a listener's selection policy
+// (see the selection package) is threaded into the proxy without the proxy
+// needing to know about policies itself.
+type ConnectionSelector func(clientAddr net.Addr) (*wireguard.Connection, error)
+
+func (m *Manager) StartProxy(ctx context.Context, port int, selector ConnectionSelector) error {
 	addr := fmt.Sprintf("0.0.0.0:%d", port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -56,7 +93,7 @@ func (m *Manager) StartProxy(ctx context.Context, index int, port int) error {
 	}
 	defer listener.Close()
 
-	log.Printf("SOCKS5 proxy listening on %s (index: %d)", addr, index)
+	log.Printf("SOCKS5 proxy listening on %s", addr)
 
 	for {
 		select {
@@ -74,11 +111,11 @@ func (m *Manager) StartProxy(ctx context.Context, index int, port int) error {
 			continue
 		}
 
-		go m.handleConnection(ctx, conn, index)
+		go m.handleConnection(ctx, conn, selector)
 	}
 }
 
-func (m *Manager) handleConnection(ctx context.Context, clientConn net.Conn, index int) {
+func (m *Manager) handleConnection(ctx context.Context, clientConn net.Conn, selector ConnectionSelector) {
 	defer clientConn.Close()
 
 	// Set deadlines
@@ -86,125 +123,386 @@ func (m *Manager) handleConnection(ctx context.Context, clientConn net.Conn, ind
 		clientConn.SetReadDeadline(time.Now().Add(time.Duration(m.cfg.ReadTimeout) * time.Second))
 	}
 
-	// SOCKS5 handshake
-	if err := m.handleHandshake(clientConn); err != nil {
+	// SOCKS5 handshake and authentication
+	authCtx, err := m.handleHandshake(clientConn)
+	if err != nil {
 		log.Printf("Handshake error: %v", err)
 		return
 	}
 
-	// Get target address
-	targetAddr, err := m.getTargetAddress(clientConn)
+	// Parse the command and its target address
+	cmd, targetAddr, atyp, err := m.parseRequest(clientConn)
 	if err != nil {
-		log.Printf("Failed to get target address: %v", err)
+		log.Printf("Failed to parse request: %v", err)
 		return
 	}
 
-	// Select WireGuard connection
-	var wgConn *wireguard.Connection
-	if index == 0 {
-		// Random selection
-		wgConn, err = m.wgManager.GetRandomHealthyConnection()
-	} else {
-		// Specific connection
-		wgConn, err = m.wgManager.GetHealthyConnection(index - 1)
+	// Evaluate egress policy against the SOCKS-supplied target before doing
+	// anything else. For CONNECT, this is necessarily based on the raw
+	// target rather than a sniffed hostname: per RFC 1928 a client never
+	// writes its application payload until it has received the CONNECT
+	// success reply, so there's nothing to sniff yet at this point — see
+	// handleConnect for where sniffing actually happens and why.
+	decision := m.evaluatePolicy(authCtx, targetAddr, "", atyp, clientConn.RemoteAddr())
+	switch decision.Action {
+	case policy.ActionDeny:
+		log.Printf("Denied by policy rule %q: %s -> %s", decision.Rule, clientConn.RemoteAddr(), targetAddr)
+		m.sendReply(clientConn, repRuleDeny, nil)
+		return
+	case policy.ActionRedispatch:
+		if cmd != cmdConnect {
+			log.Printf("Redispatch rule %q does not support command %d", decision.Rule, cmd)
+			m.sendReply(clientConn, repCommandNotSupported, nil)
+			return
+		}
+		m.handleRedispatch(clientConn, decision, targetAddr, "")
+		return
 	}
 
+	if cmd == cmdConnect {
+		m.handleConnect(ctx, clientConn, authCtx, targetAddr, atyp, decision, selector)
+		return
+	}
+
+	// Select WireGuard connection
+	wgConn, err := m.SelectConnection(ctx, authCtx, decision, targetAddr, selector, clientConn.RemoteAddr())
 	if err != nil {
 		log.Printf("No healthy connection available: %v", err)
-		m.sendConnectResponse(clientConn, repFailure)
+		m.sendReply(clientConn, repFailure, nil)
 		return
 	}
 
-	// Connect to target through WireGuard interface
-	targetConn, err := m.dialThroughInterface(wgConn.InterfaceName, targetAddr)
+	switch cmd {
+	case cmdBind:
+		m.handleBind(clientConn, wgConn)
+	case cmdUDPAssociate:
+		m.handleUDPAssociate(ctx, clientConn, wgConn)
+	default:
+		log.Printf("Unsupported command: %d", cmd)
+		m.sendReply(clientConn, repCommandNotSupported, nil)
+	}
+}
+
+// evaluatePolicy builds a policy.Request from the connection's authenticated
+// identity and parsed target, and evaluates it against m.policyEngine. A
+// non-empty sniffedHost (see the sniffer package) is used in place of the
+// SOCKS-supplied host, so rules can key on the real domain even when the
+// client connected by IP.
+func (m *Manager) evaluatePolicy(authCtx *auth.AuthContext, targetAddr, sniffedHost string, atyp byte, clientAddr net.Addr) policy.Decision {
+	var username string
+	if authCtx != nil {
+		username = authCtx.Username
+	}
+
+	host, portStr, err := net.SplitHostPort(targetAddr)
 	if err != nil {
-		log.Printf("Failed to connect to target %s: %v", targetAddr, err)
-		m.sendConnectResponse(clientConn, repFailure)
+		log.Printf("Failed to split target address %q: %v", targetAddr, err)
+		return policy.Decision{Action: policy.ActionAllow}
+	}
+	port, _ := strconv.Atoi(portStr)
+	if sniffedHost != "" {
+		host = sniffedHost
+	}
+
+	var srcIP net.IP
+	if tcpAddr, ok := clientAddr.(*net.TCPAddr); ok {
+		srcIP = tcpAddr.IP
+	}
+
+	return m.policyEngine.Evaluate(&policy.Request{
+		SrcIP:    srcIP,
+		Username: username,
+		DstHost:  host,
+		DstPort:  port,
+		Atyp:     atyp,
+	})
+}
+
+// handleRedispatch implements a "redispatch" policy decision: it chains the
+// CONNECT request through decision.RedispatchAddr instead of dialing
+// targetAddr directly, then relays between the client and that upstream
+// proxy exactly as handleConnect would for a direct dial.
+func (m *Manager) handleRedispatch(clientConn net.Conn, decision policy.Decision, targetAddr, domain string) {
+	targetConn, err := m.dialRedispatch(decision, targetAddr)
+	if err != nil {
+		log.Printf("%v", err)
+		m.sendReply(clientConn, repFailure, nil)
 		return
 	}
 	defer targetConn.Close()
 
-	// Send success response
-	if err := m.sendConnectResponse(clientConn, repSuccess); err != nil {
+	if err := m.sendReply(clientConn, repSuccess, nil); err != nil {
 		log.Printf("Failed to send connect response: %v", err)
 		return
 	}
 
+	m.relay(clientConn, targetConn, domain)
+}
+
+// relayRedispatchAfterReply is handleRedispatch's counterpart for the
+// post-sniff redispatch path in handleConnect, where the CONNECT success
+// reply has already gone out and can't be sent (or failed) a second time.
+func (m *Manager) relayRedispatchAfterReply(clientConn net.Conn, decision policy.Decision, targetAddr, domain string) {
+	targetConn, err := m.dialRedispatch(decision, targetAddr)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	defer targetConn.Close()
+
+	m.relay(clientConn, targetConn, domain)
+}
+
+// dialRedispatch dials targetAddr through the upstream SOCKS5 proxy named
+// by decision.RedispatchAddr.
+func (m *Manager) dialRedispatch(decision policy.Decision, targetAddr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return nil, fmt.Errorf("split target address %q: %w", targetAddr, err)
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	targetConn, err := policy.Redispatch("tcp", decision.RedispatchAddr, &policy.Request{DstHost: host, DstPort: port})
+	if err != nil {
+		return nil, fmt.Errorf("redispatch via rule %q to %s failed: %w", decision.Rule, decision.RedispatchAddr, err)
+	}
+	return targetConn, nil
+}
+
+// handleConnect implements the SOCKS5 CONNECT command. preliminary is the
+// egress policy decision for the raw SOCKS-supplied target, already known
+// not to be Deny/Redispatch (the caller handles those before dialing out is
+// even considered).
+//
+// Sniffing the real application hostname (see the sniffer package) can only
+// happen once the client starts writing its payload, and per RFC 1928 it
+// won't do that until it has the CONNECT success reply in hand — a
+// spec-compliant client blocks on that reply before sending anything, so
+// peeking before the reply goes out never sees bytes. handleConnect
+// therefore sends that reply first, then sniffs, then re-evaluates egress
+// policy with the sniffed hostname so domain-suffix rules (see the policy
+// package) can still gate which WireGuard connection a CONNECT dials
+// through, or block/redispatch it, even though the client was only ever
+// given targetAddr by IP. The unavoidable cost of replying before dialing is
+// that a dial failure discovered afterwards can no longer be reported back
+// via a SOCKS reply; the client just sees the connection close.
+func (m *Manager) handleConnect(ctx context.Context, clientConn net.Conn, authCtx *auth.AuthContext, targetAddr string, atyp byte, preliminary policy.Decision, selector ConnectionSelector) {
+	if err := m.sendReply(clientConn, repSuccess, nil); err != nil {
+		log.Printf("Failed to send connect response: %v", err)
+		return
+	}
+
+	sniffed, workConn := sniffer.Sniff(clientConn, time.Duration(m.cfg.SniffTimeout)*time.Millisecond)
+
+	decision := preliminary
+	if sniffed.Hostname != "" {
+		decision = m.evaluatePolicy(authCtx, targetAddr, sniffed.Hostname, atyp, clientConn.RemoteAddr())
+	}
+
+	switch decision.Action {
+	case policy.ActionDeny:
+		log.Printf("Denied by policy rule %q after sniffing %s: %s -> %s", decision.Rule, sniffed.Hostname, clientConn.RemoteAddr(), targetAddr)
+		workConn.Close()
+		return
+	case policy.ActionRedispatch:
+		m.relayRedispatchAfterReply(workConn, decision, targetAddr, sniffed.Hostname)
+		return
+	}
+
+	wgConn, err := m.SelectConnection(ctx, authCtx, decision, targetAddr, selector, clientConn.RemoteAddr())
+	if err != nil {
+		log.Printf("No healthy connection available: %v", err)
+		workConn.Close()
+		return
+	}
+
+	dialStart := time.Now()
+	targetConn, err := m.dialThroughInterface(wgConn.InterfaceName(), targetAddr)
+	if err != nil {
+		m.wgManager.ReportDialFailure(wgConn.Index, err)
+		log.Printf("Failed to connect to target %s: %v", targetAddr, err)
+		return
+	}
+	defer targetConn.Close()
+	m.wgManager.ReportDialSuccess(wgConn.Index, time.Since(dialStart))
+
 	// Update stats
-	m.stats.IncrementRequests(wgConn.Index)
+	m.stats.IncrementRequests(wgConn.Name)
 
 	// Relay data
-	m.relay(clientConn, targetConn)
+	m.relay(workConn, targetConn, sniffed.Hostname)
+}
+
+// handleBind implements the SOCKS5 BIND command: it opens a TCP listener on
+// wgConn's WireGuard interface address, replies with the bound BND.ADDR/
+// BND.PORT so the client can hand that address to its peer out-of-band (the
+// classic use is FTP active mode), then waits for that peer to dial back in
+// through the tunnel before sending a second reply and relaying. This is
+// required because BIND's whole point is accepting an inbound connection on
+// the same interface CONNECT dials out from, rather than dialing out itself.
+func (m *Manager) handleBind(clientConn net.Conn, wgConn *wireguard.Connection) {
+	address := wgConn.Address()
+	if address == "" {
+		log.Printf("Connection %s has no interface address to bind for BIND", wgConn.Name)
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, "0"))
+	if err != nil {
+		log.Printf("Failed to open BIND listener on %s: %v", address, err)
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	defer listener.Close()
+
+	bindAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		log.Printf("Unexpected BIND listener address type: %T", listener.Addr())
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	if err := m.sendReply(clientConn, repSuccess, &net.UDPAddr{IP: bindAddr.IP, Port: bindAddr.Port}); err != nil {
+		log.Printf("Failed to send first BIND response: %v", err)
+		return
+	}
+
+	if m.cfg.ReadTimeout > 0 {
+		listener.(*net.TCPListener).SetDeadline(time.Now().Add(time.Duration(m.cfg.ReadTimeout) * time.Second))
+	}
+	peerConn, err := listener.Accept()
+	if err != nil {
+		log.Printf("BIND accept on %s failed: %v", address, err)
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	defer peerConn.Close()
+
+	peerAddr, _ := peerConn.RemoteAddr().(*net.TCPAddr)
+	if err := m.sendReply(clientConn, repSuccess, &net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port}); err != nil {
+		log.Printf("Failed to send second BIND response: %v", err)
+		return
+	}
+
+	m.stats.IncrementRequests(wgConn.Name)
+	m.relay(clientConn, peerConn, "")
 }
 
-func (m *Manager) handleHandshake(conn net.Conn) error {
+// handleHandshake runs the SOCKS5 version/method negotiation (RFC 1928
+// section 3) and, once a mutually supported method is found, its
+// subnegotiation. Methods are tried in m.authenticators order; the first one
+// the client also offered is selected. If none match, the client is told so
+// via MethodNoAcceptable (0xFF), per spec.
+func (m *Manager) handleHandshake(conn net.Conn) (*auth.AuthContext, error) {
 	buf := make([]byte, 257)
 
 	// Read version and number of methods
 	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
-		return fmt.Errorf("read handshake: %w", err)
+		return nil, fmt.Errorf("read handshake: %w", err)
 	}
 
 	if buf[0] != socks5Version {
-		return fmt.Errorf("unsupported SOCKS version: %d", buf[0])
+		return nil, fmt.Errorf("unsupported SOCKS version: %d", buf[0])
 	}
 
 	nmethods := int(buf[1])
 	if nmethods == 0 {
-		return fmt.Errorf("no authentication methods")
+		return nil, fmt.Errorf("no authentication methods")
 	}
 
 	// Read methods
 	if _, err := io.ReadFull(conn, buf[:nmethods]); err != nil {
-		return fmt.Errorf("read methods: %w", err)
+		return nil, fmt.Errorf("read methods: %w", err)
+	}
+	offered := make(map[byte]bool, nmethods)
+	for _, method := range buf[:nmethods] {
+		offered[method] = true
 	}
 
-	// Send response: no authentication required
-	_, err := conn.Write([]byte{socks5Version, noAuth})
-	return err
+	for _, authenticator := range m.authenticators {
+		if !offered[authenticator.Method()] {
+			continue
+		}
+		if _, err := conn.Write([]byte{socks5Version, authenticator.Method()}); err != nil {
+			return nil, fmt.Errorf("write method selection: %w", err)
+		}
+		return authenticator.Authenticate(conn)
+	}
+
+	conn.Write([]byte{socks5Version, auth.MethodNoAcceptable})
+	return nil, fmt.Errorf("no acceptable authentication method offered")
 }
 
-func (m *Manager) getTargetAddress(conn net.Conn) (string, error) {
+// SelectConnection picks the WireGuard connection that should serve a
+// request, given the authenticated client (if any), the egress policy's
+// decision, and its target. A decision pinning a connection (a matched
+// "allow" rule's Connection) takes priority; next, an authenticated user
+// present in cfg.Auth.ConnectionMap is pinned to that connection by name;
+// everyone else falls through to it via fallback.
+func (m *Manager) SelectConnection(ctx context.Context, authCtx *auth.AuthContext, decision policy.Decision, target string, fallback ConnectionSelector, clientAddr net.Addr) (*wireguard.Connection, error) {
+	if decision.Connection != "" {
+		return m.wgManager.GetConnectionByName(decision.Connection)
+	}
+	if authCtx != nil && authCtx.Username != "" {
+		if name, ok := m.cfg.Auth.ConnectionMap[authCtx.Username]; ok {
+			return m.wgManager.GetConnectionByName(name)
+		}
+	}
+	return fallback(clientAddr)
+}
+
+// parseRequest reads a SOCKS5 request header (VER CMD RSV ATYP DST.ADDR
+// DST.PORT) and returns the command byte, the "host:port" address it
+// carries, and the ATYP byte. For CONNECT the address is the connection
+// target; for UDP ASSOCIATE it's the address the client intends to send
+// from, which most clients leave as 0.0.0.0:0 and is otherwise unused here.
+func (m *Manager) parseRequest(conn net.Conn) (byte, string, byte, error) {
 	buf := make([]byte, 4)
 
 	// Read version, command, reserved, address type
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return "", fmt.Errorf("read request header: %w", err)
+		return 0, "", 0, fmt.Errorf("read request header: %w", err)
 	}
 
 	if buf[0] != socks5Version {
-		return "", fmt.Errorf("invalid version: %d", buf[0])
+		return 0, "", 0, fmt.Errorf("invalid version: %d", buf[0])
 	}
 
-	if buf[1] != cmdConnect {
-		return "", fmt.Errorf("unsupported command: %d", buf[1])
+	addr, err := readAddress(conn, buf[3])
+	if err != nil {
+		return 0, "", 0, err
 	}
 
-	atyp := buf[3]
+	return buf[1], addr, buf[3], nil
+}
+
+// readAddress reads an ATYP-tagged DST.ADDR/DST.PORT pair as used by both
+// SOCKS5 requests and the UDP relay header, and returns it as "host:port".
+func readAddress(r io.Reader, atyp byte) (string, error) {
 	var addr string
 
 	switch atyp {
 	case atypIPv4:
 		ipBuf := make([]byte, 4)
-		if _, err := io.ReadFull(conn, ipBuf); err != nil {
+		if _, err := io.ReadFull(r, ipBuf); err != nil {
 			return "", fmt.Errorf("read IPv4: %w", err)
 		}
 		addr = net.IP(ipBuf).String()
 
 	case atypDomain:
 		lenBuf := make([]byte, 1)
-		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
 			return "", fmt.Errorf("read domain length: %w", err)
 		}
 		domainBuf := make([]byte, lenBuf[0])
-		if _, err := io.ReadFull(conn, domainBuf); err != nil {
+		if _, err := io.ReadFull(r, domainBuf); err != nil {
 			return "", fmt.Errorf("read domain: %w", err)
 		}
 		addr = string(domainBuf)
 
 	case atypIPv6:
 		ipBuf := make([]byte, 16)
-		if _, err := io.ReadFull(conn, ipBuf); err != nil {
+		if _, err := io.ReadFull(r, ipBuf); err != nil {
 			return "", fmt.Errorf("read IPv6: %w", err)
 		}
 		addr = net.IP(ipBuf).String()
@@ -213,9 +511,8 @@ func (m *Manager) getTargetAddress(conn net.Conn) (string, error) {
 		return "", fmt.Errorf("unsupported address type: %d", atyp)
 	}
 
-	// Read port
 	portBuf := make([]byte, 2)
-	if _, err := io.ReadFull(conn, portBuf); err != nil {
+	if _, err := io.ReadFull(r, portBuf); err != nil {
 		return "", fmt.Errorf("read port: %w", err)
 	}
 	port := binary.BigEndian.Uint16(portBuf)
@@ -223,15 +520,32 @@ func (m *Manager) getTargetAddress(conn net.Conn) (string, error) {
 	return fmt.Sprintf("%s:%d", addr, port), nil
 }
 
-func (m *Manager) sendConnectResponse(conn net.Conn, rep byte) error {
-	response := []byte{
-		socks5Version, // Version
-		rep,           // Reply code
-		0x00,          // Reserved
-		atypIPv4,      // Address type
-		0, 0, 0, 0,    // Bind address (0.0.0.0)
-		0, 0, // Bind port (0)
+// sendReply writes a SOCKS5 reply (VER REP RSV ATYP BND.ADDR BND.PORT). If
+// bindAddr is nil, the bind address is reported as 0.0.0.0:0, which is what
+// CONNECT replies have always done; only the BIND and UDP ASSOCIATE replies
+// carry a real BND.ADDR/BND.PORT.
+func (m *Manager) sendReply(conn net.Conn, rep byte, bindAddr *net.UDPAddr) error {
+	ip := net.IPv4zero
+	port := 0
+	if bindAddr != nil {
+		ip = bindAddr.IP
+		port = bindAddr.Port
+	}
+
+	atyp := byte(atypIPv4)
+	ipBytes := ip.To4()
+	if ipBytes == nil {
+		atyp = atypIPv6
+		ipBytes = ip.To16()
 	}
+
+	response := make([]byte, 0, 6+len(ipBytes))
+	response = append(response, socks5Version, rep, 0x00, atyp)
+	response = append(response, ipBytes...)
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(port))
+	response = append(response, portBuf...)
+
 	_, err := conn.Write(response)
 	return err
 }
@@ -254,17 +568,216 @@ func (m *Manager) dialThroughInterface(interfaceName string, targetAddr string)
 	return dialer.Dial("tcp", targetAddr)
 }
 
-func (m *Manager) relay(dst, src net.Conn) {
+// listenUDPThroughInterface allocates a UDP socket bound (via
+// SO_BINDTODEVICE) to interfaceName, so datagrams sent on it egress through
+// that WireGuard tunnel regardless of the host's routing table.
+func listenUDPThroughInterface(interfaceName string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var operr error
+			err := c.Control(func(fd uintptr) {
+				operr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, interfaceName)
+			})
+			if err != nil {
+				return err
+			}
+			return operr
+		},
+	}
+
+	packetConn, err := lc.ListenPacket(context.Background(), "udp", "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	return packetConn.(*net.UDPConn), nil
+}
+
+// handleUDPAssociate implements the SOCKS5 UDP ASSOCIATE command: it
+// allocates a UDP socket for the client to send datagrams to, relays their
+// payloads to the chosen WireGuard interface, and tears the association down
+// when the TCP control connection closes.
+func (m *Manager) handleUDPAssociate(ctx context.Context, clientConn net.Conn, wgConn *wireguard.Connection) {
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		log.Printf("Failed to allocate UDP relay socket: %v", err)
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	defer relayConn.Close()
+
+	upstream, err := listenUDPThroughInterface(wgConn.InterfaceName())
+	if err != nil {
+		log.Printf("Failed to allocate upstream UDP socket on %s: %v", wgConn.InterfaceName(), err)
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	defer upstream.Close()
+
+	bindAddr, ok := relayConn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		log.Printf("Unexpected UDP relay address type: %T", relayConn.LocalAddr())
+		m.sendReply(clientConn, repFailure, nil)
+		return
+	}
+	if err := m.sendReply(clientConn, repSuccess, bindAddr); err != nil {
+		log.Printf("Failed to send UDP associate response: %v", err)
+		return
+	}
+	m.stats.IncrementRequests(wgConn.Name)
+
+	// The control connection's read deadline (if any) is for the request
+	// phase only; the association itself is long-lived, so clear it before
+	// using the connection purely to detect closure.
+	clientConn.SetReadDeadline(time.Time{})
+
+	assocCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		// Blocks until the client closes the control connection; anything
+		// the client sends on it meanwhile is discarded.
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	m.relayUDP(assocCtx, relayConn, upstream)
+}
+
+// relayUDP pumps datagrams between the client-facing relayConn and the
+// WireGuard-bound upstream socket until ctx is cancelled. Each datagram from
+// the client carries a SOCKS5 UDP header (RSV RSV FRAG ATYP DST.ADDR
+// DST.PORT DATA); fragmented datagrams (FRAG != 0) are dropped. Replies are
+// re-wrapped with the same header format using the responding target's
+// address and sent back to the last client address seen.
+func (m *Manager) relayUDP(ctx context.Context, relayConn, upstream *net.UDPConn) {
+	var clientAddr atomic.Pointer[net.UDPAddr]
+
+	go func() {
+		buf := m.bufPool.Get().(*[]byte)
+		defer m.bufPool.Put(buf)
+		for {
+			n, from, err := upstream.ReadFromUDP(*buf)
+			if err != nil {
+				return
+			}
+			addr := clientAddr.Load()
+			if addr == nil {
+				continue
+			}
+			if _, err := relayConn.WriteToUDP(wrapUDPHeader(from, (*buf)[:n]), addr); err != nil {
+				log.Printf("Failed to relay UDP reply to client: %v", err)
+			}
+		}
+	}()
+
+	buf := m.bufPool.Get().(*[]byte)
+	defer m.bufPool.Put(buf)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		relayConn.SetReadDeadline(time.Now().Add(udpAssociationIdleTimeout))
+		n, from, err := relayConn.ReadFromUDP(*buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		clientAddr.Store(from)
+
+		targetAddr, payload, ok := parseUDPHeader((*buf)[:n])
+		if !ok {
+			continue
+		}
+		udpAddr, err := net.ResolveUDPAddr("udp", targetAddr)
+		if err != nil {
+			log.Printf("Failed to resolve UDP target %s: %v", targetAddr, err)
+			continue
+		}
+		if _, err := upstream.WriteToUDP(payload, udpAddr); err != nil {
+			log.Printf("Failed to relay UDP datagram to %s: %v", targetAddr, err)
+		}
+	}
+}
+
+// parseUDPHeader parses a client-sent SOCKS5 UDP relay header (RSV RSV FRAG
+// ATYP DST.ADDR DST.PORT DATA). It returns false for fragmented (FRAG != 0)
+// or malformed datagrams, which callers should silently drop.
+func parseUDPHeader(data []byte) (addr string, payload []byte, ok bool) {
+	if len(data) < 4 || data[2] != 0x00 {
+		return "", nil, false
+	}
+
+	r := bytes.NewReader(data[3:])
+	atyp, err := r.ReadByte()
+	if err != nil {
+		return "", nil, false
+	}
+
+	addr, err = readAddress(r, atyp)
+	if err != nil {
+		return "", nil, false
+	}
+
+	return addr, data[len(data)-r.Len():], true
+}
+
+// wrapUDPHeader builds the SOCKS5 UDP relay header for a reply received
+// from addr, so it can be forwarded to the client as-if addr were the
+// original target responding.
+func wrapUDPHeader(addr *net.UDPAddr, payload []byte) []byte {
+	atyp := byte(atypIPv4)
+	ipBytes := addr.IP.To4()
+	if ipBytes == nil {
+		atyp = atypIPv6
+		ipBytes = addr.IP.To16()
+	}
+
+	header := make([]byte, 0, 4+len(ipBytes)+2+len(payload))
+	header = append(header, 0x00, 0x00, 0x00, atyp)
+	header = append(header, ipBytes...)
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(addr.Port))
+	header = append(header, portBuf...)
+	header = append(header, payload...)
+	return header
+}
+
+// closeWriteCapable is satisfied by *net.TCPConn and sniffer.Conn (which
+// forwards to the connection it wraps). relay uses it instead of a hard
+// type assertion to *net.TCPConn so a sniffer-wrapped client connection
+// doesn't panic here.
+type closeWriteCapable interface {
+	CloseWrite() error
+}
+
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(closeWriteCapable); ok {
+		cw.CloseWrite()
+	}
+}
+
+// relay pumps bytes between dst and src until either side closes. If
+// domain is non-empty (the application hostname sniffed from the client's
+// first bytes, see the sniffer package), the bytes transferred in each
+// direction are recorded against it in stats.
+func (m *Manager) relay(dst, src net.Conn, domain string) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	var rxBytes, txBytes int64
+
 	// Copy from source to destination
 	go func() {
 		defer wg.Done()
 		buf := m.bufPool.Get().(*[]byte)
 		defer m.bufPool.Put(buf)
-		io.CopyBuffer(dst, src, *buf)
-		dst.(*net.TCPConn).CloseWrite()
+		n, _ := io.CopyBuffer(dst, src, *buf)
+		rxBytes = n
+		closeWrite(dst)
 	}()
 
 	// Copy from destination to source
@@ -272,9 +785,14 @@ func (m *Manager) relay(dst, src net.Conn) {
 		defer wg.Done()
 		buf := m.bufPool.Get().(*[]byte)
 		defer m.bufPool.Put(buf)
-		io.CopyBuffer(src, dst, *buf)
-		src.(*net.TCPConn).CloseWrite()
+		n, _ := io.CopyBuffer(src, dst, *buf)
+		txBytes = n
+		closeWrite(src)
 	}()
 
 	wg.Wait()
+
+	if domain != "" {
+		m.stats.RecordDomainBytes(domain, txBytes, rxBytes)
+	}
 }
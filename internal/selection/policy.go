@@ -0,0 +1,277 @@
+// Package selection implements pluggable upstream-selection policies used by
+// the SOCKS5 proxy to pick which WireGuard connection should serve a given
+// request.
+package selection
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/tomventa/wirebalancer/internal/stats"
+	"github.com/tomventa/wirebalancer/internal/wireguard"
+)
+
+// ErrNoHealthyConnection is returned by a Policy when none of the supplied
+// connections are currently healthy.
+var ErrNoHealthyConnection = errors.New("no healthy connection available")
+
+// ProxyRequest carries request-scoped information a Policy may use to make
+// its selection, such as the SOCKS5 client's remote address for policies
+// that need client affinity.
+type ProxyRequest struct {
+	ClientAddr net.Addr
+}
+
+// Policy selects one WireGuard connection out of a set of candidates for a
+// given proxy request.
+type Policy interface {
+	// Name returns the policy identifier as used in configuration.
+	Name() string
+	// Select picks a connection out of conns, which are not all necessarily
+	// healthy. It returns ErrNoHealthyConnection if none qualify.
+	Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error)
+}
+
+// New builds the Policy identified by name. An empty name defaults to
+// "random" to match the proxy's historical behavior.
+func New(name string, statsTracker *stats.Tracker, wgManager *wireguard.Manager) (Policy, error) {
+	switch name {
+	case "", "random":
+		return NewRandomPolicy(), nil
+	case "round_robin":
+		return NewRoundRobinPolicy(), nil
+	case "least_requests":
+		return NewLeastRequestsPolicy(statsTracker), nil
+	case "least_latency":
+		return NewLeastLatencyPolicy(statsTracker), nil
+	case "weighted":
+		return NewWeightedPolicy(), nil
+	case "ip_hash":
+		return NewIPHashPolicy(), nil
+	case "suggest":
+		return NewSuggestPolicy(wgManager), nil
+	default:
+		return nil, fmt.Errorf("unknown selection policy: %q", name)
+	}
+}
+
+func healthyConns(conns []*wireguard.Connection) []*wireguard.Connection {
+	healthy := make([]*wireguard.Connection, 0, len(conns))
+	for _, c := range conns {
+		if c.IsHealthy() {
+			healthy = append(healthy, c)
+		}
+	}
+	return healthy
+}
+
+// RoundRobinPolicy cycles through the healthy connections in order, skipping
+// any that are currently unhealthy.
+type RoundRobinPolicy struct {
+	counter atomic.Uint64
+}
+
+func NewRoundRobinPolicy() *RoundRobinPolicy {
+	return &RoundRobinPolicy{}
+}
+
+func (p *RoundRobinPolicy) Name() string { return "round_robin" }
+
+func (p *RoundRobinPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+	idx := p.counter.Add(1) - 1
+	return healthy[idx%uint64(len(healthy))], nil
+}
+
+// RandomPolicy picks uniformly at random among the healthy connections using
+// a cryptographically secure source.
+type RandomPolicy struct{}
+
+func NewRandomPolicy() *RandomPolicy {
+	return &RandomPolicy{}
+}
+
+func (p *RandomPolicy) Name() string { return "random" }
+
+func (p *RandomPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(healthy))))
+	if err != nil {
+		return nil, fmt.Errorf("generating random index: %w", err)
+	}
+	return healthy[n.Int64()], nil
+}
+
+// LeastRequestsPolicy picks the healthy connection with the fewest requests
+// served so far, spreading load away from busy tunnels.
+type LeastRequestsPolicy struct {
+	stats *stats.Tracker
+}
+
+func NewLeastRequestsPolicy(statsTracker *stats.Tracker) *LeastRequestsPolicy {
+	return &LeastRequestsPolicy{stats: statsTracker}
+}
+
+func (p *LeastRequestsPolicy) Name() string { return "least_requests" }
+
+func (p *LeastRequestsPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+
+	best := healthy[0]
+	bestCount := p.stats.GetConnectionRequests(best.Name)
+	for _, c := range healthy[1:] {
+		if count := p.stats.GetConnectionRequests(c.Name); count < bestCount {
+			best, bestCount = c, count
+		}
+	}
+	return best, nil
+}
+
+// LeastLatencyPolicy picks the healthy connection with the lowest average
+// latency, as recorded from health checks and proxy dials. Connections
+// without any latency samples yet are deprioritized but still eligible.
+type LeastLatencyPolicy struct {
+	stats *stats.Tracker
+}
+
+func NewLeastLatencyPolicy(statsTracker *stats.Tracker) *LeastLatencyPolicy {
+	return &LeastLatencyPolicy{stats: statsTracker}
+}
+
+func (p *LeastLatencyPolicy) Name() string { return "least_latency" }
+
+func (p *LeastLatencyPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+
+	var best *wireguard.Connection
+	var bestLatency time.Duration
+	for _, c := range healthy {
+		latency := p.stats.GetAverageLatency(c.Name)
+		if latency == 0 {
+			// No samples yet: still eligible, just not preferred over a
+			// connection with a known latency.
+			latency = time.Hour
+		}
+		if best == nil || latency < bestLatency {
+			best, bestLatency = c, latency
+		}
+	}
+	return best, nil
+}
+
+// WeightedPolicy picks a healthy connection at random, biased by each
+// connection's configured weight. Connections with a weight <= 0 default to
+// a weight of 1.
+type WeightedPolicy struct{}
+
+func NewWeightedPolicy() *WeightedPolicy {
+	return &WeightedPolicy{}
+}
+
+func (p *WeightedPolicy) Name() string { return "weighted" }
+
+func connWeight(c *wireguard.Connection) int {
+	if w := c.Weight(); w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (p *WeightedPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+
+	total := 0
+	for _, c := range healthy {
+		total += connWeight(c)
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(total)))
+	if err != nil {
+		return nil, fmt.Errorf("generating weighted index: %w", err)
+	}
+
+	target := n.Int64()
+	for _, c := range healthy {
+		w := int64(connWeight(c))
+		if target < w {
+			return c, nil
+		}
+		target -= w
+	}
+	return healthy[len(healthy)-1], nil
+}
+
+// IPHashPolicy hashes the SOCKS5 client's remote IP with FNV-1a and maps it
+// onto one of the healthy connections, keeping a given client on the same
+// tunnel across requests.
+type IPHashPolicy struct{}
+
+func NewIPHashPolicy() *IPHashPolicy {
+	return &IPHashPolicy{}
+}
+
+func (p *IPHashPolicy) Name() string { return "ip_hash" }
+
+func (p *IPHashPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	healthy := healthyConns(conns)
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyConnection
+	}
+
+	if req == nil || req.ClientAddr == nil {
+		return healthy[0], nil
+	}
+
+	host := req.ClientAddr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(host))
+	idx := h.Sum32() % uint32(len(healthy))
+	return healthy[idx], nil
+}
+
+// SuggestPolicy delegates to wireguard.Manager.SuggestConnection, Tailscale
+// exit-node-style ranking by latency, passive failure rate, and handshake
+// freshness, rather than the simpler single-signal heuristics of the other
+// policies.
+type SuggestPolicy struct {
+	wgManager *wireguard.Manager
+}
+
+func NewSuggestPolicy(wgManager *wireguard.Manager) *SuggestPolicy {
+	return &SuggestPolicy{wgManager: wgManager}
+}
+
+func (p *SuggestPolicy) Name() string { return "suggest" }
+
+func (p *SuggestPolicy) Select(conns []*wireguard.Connection, req *ProxyRequest) (*wireguard.Connection, error) {
+	conn, _, err := p.wgManager.SuggestConnection()
+	if err != nil {
+		return nil, ErrNoHealthyConnection
+	}
+	return conn, nil
+}
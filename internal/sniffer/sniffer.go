@@ -0,0 +1,281 @@
+// Package sniffer identifies the application protocol carried by a freshly
+// accepted SOCKS5 CONNECT, by peeking at the first bytes the client sends —
+// before the destination WireGuard connection is even chosen and well
+// before the proxy dials out. This lets egress policy rules and per-domain
+// stats key off the real TLS SNI / HTTP Host, even when the client
+// connected to the proxy by IP address rather than by name.
+package sniffer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds how long Sniff waits for enough client bytes to
+// identify a protocol before giving up and falling back to the
+// SOCKS-supplied address.
+const DefaultTimeout = 100 * time.Millisecond
+
+// maxPeekBytes caps how much of the client's first flight Sniff buffers.
+// It comfortably fits a typical TLS ClientHello or an HTTP request line
+// plus headers; anything beyond it is left for the relay to read normally.
+const maxPeekBytes = 4096
+
+// Result is the outcome of sniffing a connection.
+type Result struct {
+	// Hostname is the application-layer hostname extracted from the
+	// sniffed bytes (TLS SNI or HTTP Host). Empty if no parser recognized
+	// the bytes, recognized them too late, or the protocol carries no
+	// hostname the sniffer can recover (e.g. QUIC Initial, which is
+	// detected but not decrypted).
+	Hostname string
+	// Protocol names which parser matched ("tls", "http", "quic"), empty
+	// if none did.
+	Protocol string
+}
+
+// Conn wraps a net.Conn so that bytes consumed by Sniff's peek are replayed
+// transparently to whatever reads from Conn afterwards — typically the
+// relay loop forwarding the client's bytes to the dialed target. Writes,
+// Close, and deadlines pass straight through to the wrapped connection.
+type Conn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *Conn) Read(b []byte) (int, error) { return c.br.Read(b) }
+
+// CloseWrite forwards to the wrapped connection's CloseWrite, if it has
+// one (true for the *net.TCPConn the proxy accepts connections as). It's a
+// no-op otherwise, matching how callers already treat CloseWrite as
+// best-effort.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// Sniff peeks at conn's first bytes for up to timeout (DefaultTimeout if
+// <= 0), trying to identify the application protocol. It returns the
+// Result — whose Hostname is empty if nothing matched in time — and a
+// net.Conn to use in place of conn for all further reads and writes: it
+// replays the peeked bytes first, then falls through to conn.
+func Sniff(conn net.Conn, timeout time.Duration) (Result, net.Conn) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	br := bufio.NewReaderSize(conn, maxPeekBytes)
+	wrapped := &Conn{Conn: conn, br: br}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Grow the peek one byte at a time instead of asking for maxPeekBytes
+	// up front. Peek only reads from conn once the bytes already buffered
+	// are used up, and bufio's fill keeps whatever a single Read call
+	// returns, so a parser sees an already-arrived, in-spec payload (one
+	// TLS record, one HTTP request line) as soon as it's read, rather than
+	// blocking for the whole timeout waiting for bytes that were never
+	// coming.
+	var peeked []byte
+	for {
+		want := len(peeked) + 1
+		if want > maxPeekBytes {
+			want = maxPeekBytes
+		}
+		b, err := br.Peek(want) // a short/timeout/EOF error is expected; sniff whatever arrived
+		peeked = b
+		if result := identify(peeked); result.Protocol != "" {
+			return result, wrapped
+		}
+		if err != nil || len(peeked) >= maxPeekBytes {
+			return identify(peeked), wrapped
+		}
+	}
+}
+
+// identify tries each protocol parser in turn against the peeked bytes,
+// returning the first match.
+func identify(b []byte) Result {
+	if host, ok := sniffTLS(b); ok {
+		return Result{Hostname: host, Protocol: "tls"}
+	}
+	if host, ok := sniffHTTP(b); ok {
+		return Result{Hostname: host, Protocol: "http"}
+	}
+	if looksLikeQUICInitial(b) {
+		// QUIC Initial packets are encrypted with a version-specific but
+		// publicly known key, so the SNI is in principle recoverable, but
+		// that decryption isn't implemented here. Reporting "quic" without
+		// a hostname still lets rules route on protocol alone and keeps
+		// the sniffer honest about what it didn't extract.
+		return Result{Protocol: "quic"}
+	}
+	return Result{}
+}
+
+// sniffTLS parses b as a TLS record containing a ClientHello and extracts
+// the SNI host_name entry from its server_name extension (0x0000), per RFC
+// 8446 section 4.1.2 / RFC 6066 section 3. It returns ok=false for anything
+// that isn't a well-formed ClientHello within b, including one that's been
+// truncated by the peek window — there's no partial-record fallback.
+func sniffTLS(b []byte) (string, bool) {
+	// TLS record header: ContentType(1) ProtocolVersion(2) Length(2).
+	const recordHeaderLen = 5
+	if len(b) < recordHeaderLen || b[0] != 0x16 {
+		return "", false
+	}
+	recordLen := int(binary.BigEndian.Uint16(b[3:5]))
+	if len(b) < recordHeaderLen+recordLen {
+		return "", false
+	}
+	body := b[recordHeaderLen : recordHeaderLen+recordLen]
+
+	// Handshake header: HandshakeType(1) length(3).
+	if len(body) < 4 || body[0] != 0x01 {
+		return "", false
+	}
+	helloLen := int(body[1])<<16 | int(body[2])<<8 | int(body[3])
+	if len(body) < 4+helloLen {
+		return "", false
+	}
+	hello := body[4 : 4+helloLen]
+
+	// ClientVersion(2) Random(32) SessionIDLen(1)+SessionID.
+	pos := 34
+	if len(hello) < pos+1 {
+		return "", false
+	}
+	pos += 1 + int(hello[pos])
+
+	// CipherSuitesLen(2)+CipherSuites.
+	if len(hello) < pos+2 {
+		return "", false
+	}
+	pos += 2 + int(binary.BigEndian.Uint16(hello[pos:pos+2]))
+
+	// CompressionMethodsLen(1)+CompressionMethods.
+	if len(hello) < pos+1 {
+		return "", false
+	}
+	pos += 1 + int(hello[pos])
+
+	// ExtensionsLen(2)+Extensions; a ClientHello with no extensions can't
+	// carry SNI.
+	if len(hello) < pos+2 {
+		return "", false
+	}
+	extensionsLen := int(binary.BigEndian.Uint16(hello[pos : pos+2]))
+	pos += 2
+	if len(hello) < pos+extensionsLen {
+		return "", false
+	}
+	extensions := hello[pos : pos+extensionsLen]
+
+	for len(extensions) >= 4 {
+		extType := binary.BigEndian.Uint16(extensions[0:2])
+		extLen := int(binary.BigEndian.Uint16(extensions[2:4]))
+		if len(extensions) < 4+extLen {
+			return "", false
+		}
+		extData := extensions[4 : 4+extLen]
+		extensions = extensions[4+extLen:]
+
+		if extType != 0x0000 { // server_name
+			continue
+		}
+		// ServerNameList: ListLen(2), then entries of NameType(1)
+		// NameLen(2) Name.
+		if len(extData) < 2 {
+			continue
+		}
+		list := extData[2:]
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(binary.BigEndian.Uint16(list[1:3]))
+			if len(list) < 3+nameLen {
+				break
+			}
+			name := list[3 : 3+nameLen]
+			if nameType == 0x00 { // host_name
+				return string(name), true
+			}
+			list = list[3+nameLen:]
+		}
+	}
+	return "", false
+}
+
+// sniffHTTP parses b as the start of an HTTP/1.x request and extracts its
+// Host header. It requires the request line and the Host header to both be
+// present in b; a request whose Host header falls past the peek window is
+// reported as not matching rather than guessed at.
+func sniffHTTP(b []byte) (string, bool) {
+	if !looksLikeHTTPRequest(b) {
+		return "", false
+	}
+
+	// Only complete lines count: a Host line still arriving would otherwise
+	// yield a truncated hostname.
+	end := bytes.LastIndex(b, []byte("\r\n"))
+	if end < 0 {
+		return "", false
+	}
+
+	for _, line := range strings.Split(string(b[:end]), "\r\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return host, host != ""
+	}
+	return "", false
+}
+
+// httpMethods are the request methods sniffHTTP recognizes to decide
+// whether b looks like an HTTP request at all, before scanning for Host.
+var httpMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT "}
+
+func looksLikeHTTPRequest(b []byte) bool {
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(b, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeQUICInitial reports whether b starts with a QUIC long-header
+// Initial packet (RFC 9000 section 17.2): the form bit and fixed bit set,
+// and packet type bits indicating Initial.
+func looksLikeQUICInitial(b []byte) bool {
+	if len(b) < 5 {
+		return false
+	}
+	const (
+		longHeaderForm = 0x80
+		fixedBit       = 0x40
+		packetTypeMask = 0x30
+		initialType    = 0x00
+	)
+	if b[0]&(longHeaderForm|fixedBit) != (longHeaderForm | fixedBit) {
+		return false
+	}
+	if b[0]&packetTypeMask != initialType {
+		return false
+	}
+	// Version must be a real, non-zero QUIC version; 0x00000000 is
+	// reserved for version negotiation packets, which aren't Initial.
+	version := binary.BigEndian.Uint32(b[1:5])
+	return version != 0
+}
@@ -0,0 +1,119 @@
+package sniffer
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello is a minimal but well-formed TLS ClientHello record carrying
+// the SNI extension for "example.com", used to drive Sniff as a real client
+// would.
+var clientHello = buildClientHello("example.com")
+
+func buildClientHello(host string) []byte {
+	serverName := append([]byte{0x00, byte(len(host) >> 8), byte(len(host))}, host...)
+	serverNameList := append([]byte{byte(len(serverName) >> 8), byte(len(serverName))}, serverName...)
+	sniExt := append([]byte{0x00, 0x00, byte(len(serverNameList) >> 8), byte(len(serverNameList))}, serverNameList...)
+
+	hello := []byte{0x03, 0x03}                   // client version
+	hello = append(hello, make([]byte, 32)...)    // random
+	hello = append(hello, 0x00)                   // session ID len
+	hello = append(hello, 0x00, 0x02, 0x00, 0x00) // cipher suites: len + one suite
+	hello = append(hello, 0x01, 0x00)             // compression methods: len + null
+	hello = append(hello, byte(len(sniExt)>>8), byte(len(sniExt)))
+	hello = append(hello, sniExt...)
+
+	body := append([]byte{0x01, byte(len(hello) >> 16), byte(len(hello) >> 8), byte(len(hello))}, hello...)
+	record := append([]byte{0x16, 0x03, 0x03, byte(len(body) >> 8), byte(len(body))}, body...)
+	return record
+}
+
+// dialPair returns a connected client/server net.Conn pair over loopback
+// TCP, rather than net.Pipe, so buffering behaves like a real socket: a
+// client write before the server reads lands in the kernel buffer instead
+// of blocking.
+func dialPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	acceptc := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			acceptc <- nil
+			return
+		}
+		acceptc <- c
+	}()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	server = <-acceptc
+	if server == nil {
+		t.Fatalf("accept failed")
+	}
+	return client, server
+}
+
+// TestSniff_BeforeReply reproduces a spec-compliant SOCKS5 client: it never
+// writes anything until it has received the CONNECT success reply. A server
+// that peeks before sending that reply (the ordering this package's caller
+// used to use) has nothing to read and times out with an empty result.
+func TestSniff_BeforeReply(t *testing.T) {
+	client, server := dialPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	start := time.Now()
+	result, _ := Sniff(server, 20*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Sniff took %v, want roughly the 20ms timeout", elapsed)
+	}
+
+	if result.Hostname != "" || result.Protocol != "" {
+		t.Fatalf("expected no sniff result when peeking before the reply, got %+v", result)
+	}
+}
+
+// TestSniff_AfterReply is the fixed ordering: the server sends its reply
+// first, which unblocks the spec-compliant client to write its ClientHello,
+// which Sniff then successfully reads.
+func TestSniff_AfterReply(t *testing.T) {
+	client, server := dialPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reply := make([]byte, 1)
+		io.ReadFull(client, reply)
+		client.Write(clientHello)
+	}()
+
+	if _, err := server.Write([]byte{0x00}); err != nil {
+		t.Fatalf("write reply: %v", err)
+	}
+
+	result, wrapped := Sniff(server, time.Second)
+	<-done
+
+	if result.Protocol != "tls" || result.Hostname != "example.com" {
+		t.Fatalf("Sniff() = %+v, want tls/example.com", result)
+	}
+
+	// The peeked bytes must still be readable from the returned conn, for
+	// the relay loop that forwards them on.
+	buf := make([]byte, len(clientHello))
+	if _, err := io.ReadFull(wrapped, buf); err != nil {
+		t.Fatalf("reading replayed bytes: %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,45 +15,125 @@ type ConnectionStats struct {
 	LastCheckTime atomic.Int64 // unix timestamp
 }
 
+func newConnectionStats() *ConnectionStats {
+	cs := &ConnectionStats{}
+	cs.IsHealthy.Store(false)
+	return cs
+}
+
+// DomainStats tracks egress byte counts observed for a sniffed application
+// hostname (see the sniffer package), independent of which WireGuard
+// connection actually carried it.
+type DomainStats struct {
+	BytesTx atomic.Int64 // client -> target
+	BytesRx atomic.Int64 // target -> client
+}
+
+// RequestObserver is notified whenever IncrementRequests is called, after the
+// internal counters have been updated.
+type RequestObserver func(name string)
+
+// LatencyObserver is notified whenever RecordLatency is called, after the
+// internal counters have been updated.
+type LatencyObserver func(name string, latency time.Duration)
+
+// Tracker is keyed by connection name rather than a fixed-size slice so that
+// connections can be added and removed at runtime (see
+// wireguard.Manager.AddConnection/RemoveConnection) without needing a
+// Resize/reindex step.
 type Tracker struct {
 	totalRequests atomic.Int64
-	connections   []ConnectionStats
 	startTime     time.Time
-	mu            sync.RWMutex
+
+	mu          sync.RWMutex
+	connections map[string]*ConnectionStats
+	onRequest   RequestObserver
+	onLatency   LatencyObserver
+
+	domainMu sync.RWMutex
+	domains  map[string]*DomainStats
 }
 
-func NewTracker(numConnections int) *Tracker {
+func NewTracker(names []string) *Tracker {
 	t := &Tracker{
-		connections: make([]ConnectionStats, numConnections),
+		connections: make(map[string]*ConnectionStats, len(names)),
+		domains:     make(map[string]*DomainStats),
 		startTime:   time.Now(),
 	}
+	for _, name := range names {
+		t.connections[name] = newConnectionStats()
+	}
+	return t
+}
 
-	// Initialize all connections as unhealthy by default
-	for i := range t.connections {
-		t.connections[i].IsHealthy.Store(false)
+// AddConnection starts tracking stats for a newly added connection. It is a
+// no-op if name is already tracked.
+func (t *Tracker) AddConnection(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.connections[name]; ok {
+		return
 	}
+	t.connections[name] = newConnectionStats()
+}
 
-	return t
+// RemoveConnection stops tracking stats for name.
+func (t *Tracker) RemoveConnection(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.connections, name)
+}
+
+func (t *Tracker) get(name string) (*ConnectionStats, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	cs, ok := t.connections[name]
+	return cs, ok
 }
 
-func (t *Tracker) IncrementRequests(connectionIndex int) {
+// SetObservers registers callbacks invoked on every IncrementRequests and
+// RecordLatency call. This lets external packages (e.g. the Prometheus
+// exporter in internal/webserver) react to live events without this package
+// needing to depend on them. Either observer may be nil.
+func (t *Tracker) SetObservers(onRequest RequestObserver, onLatency LatencyObserver) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onRequest = onRequest
+	t.onLatency = onLatency
+}
+
+func (t *Tracker) IncrementRequests(name string) {
 	t.totalRequests.Add(1)
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		t.connections[connectionIndex].RequestCount.Add(1)
+	if cs, ok := t.get(name); ok {
+		cs.RequestCount.Add(1)
+	}
+
+	t.mu.RLock()
+	observer := t.onRequest
+	t.mu.RUnlock()
+	if observer != nil {
+		observer(name)
 	}
 }
 
-func (t *Tracker) SetConnectionHealth(connectionIndex int, healthy bool) {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		t.connections[connectionIndex].IsHealthy.Store(healthy)
-		t.connections[connectionIndex].LastCheckTime.Store(time.Now().Unix())
+func (t *Tracker) SetConnectionHealth(name string, healthy bool) {
+	if cs, ok := t.get(name); ok {
+		cs.IsHealthy.Store(healthy)
+		cs.LastCheckTime.Store(time.Now().Unix())
 	}
 }
 
-func (t *Tracker) RecordLatency(connectionIndex int, latency time.Duration) {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		t.connections[connectionIndex].LatencySum.Add(int64(latency))
-		t.connections[connectionIndex].LatencyCount.Add(1)
+func (t *Tracker) RecordLatency(name string, latency time.Duration) {
+	if cs, ok := t.get(name); ok {
+		cs.LatencySum.Add(int64(latency))
+		cs.LatencyCount.Add(1)
+	}
+
+	t.mu.RLock()
+	observer := t.onLatency
+	t.mu.RUnlock()
+	if observer != nil {
+		observer(name, latency)
 	}
 }
 
@@ -60,24 +141,24 @@ func (t *Tracker) GetTotalRequests() int64 {
 	return t.totalRequests.Load()
 }
 
-func (t *Tracker) GetConnectionRequests(connectionIndex int) int64 {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		return t.connections[connectionIndex].RequestCount.Load()
+func (t *Tracker) GetConnectionRequests(name string) int64 {
+	if cs, ok := t.get(name); ok {
+		return cs.RequestCount.Load()
 	}
 	return 0
 }
 
-func (t *Tracker) IsConnectionHealthy(connectionIndex int) bool {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		return t.connections[connectionIndex].IsHealthy.Load()
+func (t *Tracker) IsConnectionHealthy(name string) bool {
+	if cs, ok := t.get(name); ok {
+		return cs.IsHealthy.Load()
 	}
 	return false
 }
 
-func (t *Tracker) GetAverageLatency(connectionIndex int) time.Duration {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		sum := t.connections[connectionIndex].LatencySum.Load()
-		count := t.connections[connectionIndex].LatencyCount.Load()
+func (t *Tracker) GetAverageLatency(name string) time.Duration {
+	if cs, ok := t.get(name); ok {
+		sum := cs.LatencySum.Load()
+		count := cs.LatencyCount.Load()
 		if count == 0 {
 			return 0
 		}
@@ -86,9 +167,9 @@ func (t *Tracker) GetAverageLatency(connectionIndex int) time.Duration {
 	return 0
 }
 
-func (t *Tracker) GetLastCheckTime(connectionIndex int) time.Time {
-	if connectionIndex >= 0 && connectionIndex < len(t.connections) {
-		timestamp := t.connections[connectionIndex].LastCheckTime.Load()
+func (t *Tracker) GetLastCheckTime(name string) time.Time {
+	if cs, ok := t.get(name); ok {
+		timestamp := cs.LastCheckTime.Load()
 		if timestamp == 0 {
 			return time.Time{}
 		}
@@ -102,24 +183,107 @@ func (t *Tracker) GetUptime() time.Duration {
 }
 
 func (t *Tracker) GetNumConnections() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return len(t.connections)
 }
 
+// ConnectionNames returns the names of all tracked connections, sorted for a
+// stable iteration order.
+func (t *Tracker) ConnectionNames() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	names := make([]string, 0, len(t.connections))
+	for name := range t.connections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RecordDomainBytes adds txBytes/rxBytes to domain's counters, creating an
+// entry for it on first use. Domains come from protocol sniffing rather
+// than config, so they can't be pre-registered like WireGuard connections.
+// A no-op if domain is empty (nothing was sniffed).
+func (t *Tracker) RecordDomainBytes(domain string, txBytes, rxBytes int64) {
+	if domain == "" {
+		return
+	}
+
+	t.domainMu.RLock()
+	ds, ok := t.domains[domain]
+	t.domainMu.RUnlock()
+
+	if !ok {
+		t.domainMu.Lock()
+		ds, ok = t.domains[domain]
+		if !ok {
+			ds = &DomainStats{}
+			t.domains[domain] = ds
+		}
+		t.domainMu.Unlock()
+	}
+
+	ds.BytesTx.Add(txBytes)
+	ds.BytesRx.Add(rxBytes)
+}
+
+// DomainStat is the JSON-serializable view of one domain's byte counters.
+type DomainStat struct {
+	Domain  string `json:"domain"`
+	BytesTx int64  `json:"bytes_tx"`
+	BytesRx int64  `json:"bytes_rx"`
+}
+
+// GetDomainStats returns byte counters for every domain observed so far,
+// sorted by domain name for stable output.
+func (t *Tracker) GetDomainStats() []DomainStat {
+	t.domainMu.RLock()
+	defer t.domainMu.RUnlock()
+
+	out := make([]DomainStat, 0, len(t.domains))
+	for domain, ds := range t.domains {
+		out = append(out, DomainStat{
+			Domain:  domain,
+			BytesTx: ds.BytesTx.Load(),
+			BytesRx: ds.BytesRx.Load(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Domain < out[j].Domain })
+	return out
+}
+
 type Stats struct {
 	TotalRequests int64            `json:"total_requests"`
 	Uptime        string           `json:"uptime"`
 	UptimeSeconds int64            `json:"uptime_seconds"`
 	Connections   []ConnectionStat `json:"connections"`
+	Domains       []DomainStat     `json:"domains"`
 }
 
 type ConnectionStat struct {
-	Index          int     `json:"index"`
-	Name           string  `json:"name"`
-	Healthy        bool    `json:"healthy"`
-	RequestCount   int64   `json:"request_count"`
-	AverageLatency string  `json:"average_latency"`
-	LatencyMs      float64 `json:"latency_ms"`
-	LastCheck      string  `json:"last_check"`
+	Index          int        `json:"index"`
+	Name           string     `json:"name"`
+	Healthy        bool       `json:"healthy"`
+	RequestCount   int64      `json:"request_count"`
+	AverageLatency string     `json:"average_latency"`
+	LatencyMs      float64    `json:"latency_ms"`
+	LastCheck      string     `json:"last_check"`
+	Peers          []PeerStat `json:"peers"`
+}
+
+// PeerStat is the JSON-serializable view of a WireGuard peer's telemetry, as
+// parsed from `wg show <iface> dump`.
+type PeerStat struct {
+	PublicKey           string  `json:"public_key"`
+	Endpoint            string  `json:"endpoint"`
+	AllowedIPs          string  `json:"allowed_ips"`
+	LastHandshake       string  `json:"last_handshake"`
+	BytesRx             int64   `json:"bytes_rx"`
+	BytesTx             int64   `json:"bytes_tx"`
+	PersistentKeepalive int     `json:"persistent_keepalive"`
+	RxBps               float64 `json:"rx_bps"`
+	TxBps               float64 `json:"tx_bps"`
 }
 
 func (t *Tracker) GetStats() Stats {
@@ -128,6 +292,7 @@ func (t *Tracker) GetStats() Stats {
 		TotalRequests: t.GetTotalRequests(),
 		Uptime:        uptime.String(),
 		UptimeSeconds: int64(uptime.Seconds()),
-		Connections:   make([]ConnectionStat, 0, len(t.connections)),
+		Connections:   make([]ConnectionStat, 0, t.GetNumConnections()),
+		Domains:       t.GetDomainStats(),
 	}
 }
@@ -0,0 +1,140 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tomventa/wirebalancer/internal/config"
+)
+
+// requireAdmin wraps handler with bearer-token auth against s.adminToken. If
+// adminToken is empty, the admin API is disabled entirely and every request
+// is rejected, regardless of what's presented.
+func (s *Server) requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			http.Error(w, "admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) || subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(s.adminToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// handleAdminConfig returns the live WireGuard configuration, reflecting any
+// connections added, removed, or updated via handleAdminReload since
+// startup.
+func (s *Server) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.wgManager.RunningConfig()); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// handleAdminReload accepts a full YAML config body and diffs its
+// wireguard.connections against the running set by name: new names are
+// added, removed names are torn down, and names present in both but with a
+// different interface, config path, or weight are updated in place.
+// Unrelated config sections (proxy, webserver) are ignored; this endpoint
+// only ever touches WireGuard connections.
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "reading request body", http.StatusBadRequest)
+		return
+	}
+
+	newCfg, err := config.Parse(body)
+	if err != nil {
+		http.Error(w, "parsing config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	added, updated, removed := diffConnections(s.wgManager.RunningConfig().Connections, newCfg.WireGuard.Connections)
+
+	var errs []string
+	for _, name := range removed {
+		if err := s.wgManager.RemoveConnection(name); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, connCfg := range updated {
+		if err := s.wgManager.UpdateConnection(connCfg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, connCfg := range added {
+		if err := s.wgManager.AddConnection(connCfg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(errs) > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"added":   connectionNames(added),
+		"updated": connectionNames(updated),
+		"removed": removed,
+	})
+}
+
+// diffConnections compares the running connection set against the newly
+// posted one by name, returning the connections to add, the connections to
+// update (name present in both, but other fields differ), and the names to
+// remove.
+func diffConnections(running, next []config.ConnectionConfig) (added, updated []config.ConnectionConfig, removed []string) {
+	runningByName := make(map[string]config.ConnectionConfig, len(running))
+	for _, c := range running {
+		runningByName[c.Name] = c
+	}
+
+	nextByName := make(map[string]bool, len(next))
+	for _, c := range next {
+		nextByName[c.Name] = true
+		old, exists := runningByName[c.Name]
+		if !exists {
+			added = append(added, c)
+			continue
+		}
+		if old != c {
+			updated = append(updated, c)
+		}
+	}
+
+	for _, c := range running {
+		if !nextByName[c.Name] {
+			removed = append(removed, c.Name)
+		}
+	}
+
+	return added, updated, removed
+}
+
+func connectionNames(connCfgs []config.ConnectionConfig) []string {
+	names := make([]string, len(connCfgs))
+	for i, c := range connCfgs {
+		names[i] = c.Name
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package webserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tomventa/wirebalancer/internal/wireguard"
+)
+
+// metrics bundles the Prometheus collectors exposed on /metrics. The request
+// counter and latency histogram are fed live from stats.Tracker observers;
+// WireGuard state (health, peer handshakes, peer byte counters) is read on
+// each scrape by stateCollector.
+type metrics struct {
+	registry           *prometheus.Registry
+	requestsTotal      *prometheus.CounterVec
+	healthcheckLatency *prometheus.HistogramVec
+}
+
+func newMetrics(wgManager *wireguard.Manager) *metrics {
+	registry := prometheus.NewRegistry()
+
+	m := &metrics{
+		registry: registry,
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wirebalancer_requests_total",
+			Help: "Total number of proxied requests served by a connection.",
+		}, []string{"connection"}),
+		healthcheckLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wirebalancer_healthcheck_latency_seconds",
+			Help:    "Latency per connection, from active health checks and successful proxy dials.",
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"connection"}),
+	}
+
+	registry.MustRegister(m.requestsTotal, m.healthcheckLatency)
+	registry.MustRegister(newStateCollector(wgManager))
+
+	return m
+}
+
+var (
+	connectionUpDesc = prometheus.NewDesc(
+		"wirebalancer_connection_up",
+		"Whether a WireGuard connection is currently healthy (1) or not (0).",
+		[]string{"connection"}, nil,
+	)
+	lastHandshakeDesc = prometheus.NewDesc(
+		"wirebalancer_last_handshake_timestamp_seconds",
+		"Unix timestamp of the last WireGuard handshake for a peer.",
+		[]string{"connection", "peer"}, nil,
+	)
+	peerBytesDesc = prometheus.NewDesc(
+		"wirebalancer_peer_bytes_total",
+		"Cumulative bytes transferred for a WireGuard peer, as reported by wg show dump.",
+		[]string{"connection", "peer", "direction"}, nil,
+	)
+)
+
+// stateCollector exposes point-in-time WireGuard state (health, peer
+// handshakes, peer byte counters) by reading wireguard.Manager directly on
+// each scrape, rather than caching values.
+type stateCollector struct {
+	wgManager *wireguard.Manager
+}
+
+func newStateCollector(wgManager *wireguard.Manager) *stateCollector {
+	return &stateCollector{wgManager: wgManager}
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionUpDesc
+	ch <- lastHandshakeDesc
+	ch <- peerBytesDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, conn := range c.wgManager.GetConnections() {
+		up := 0.0
+		if conn.IsHealthy() {
+			up = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(connectionUpDesc, prometheus.GaugeValue, up, conn.Name)
+
+		for _, peer := range conn.PeerStats() {
+			if !peer.LastHandshake.IsZero() {
+				ch <- prometheus.MustNewConstMetric(lastHandshakeDesc, prometheus.GaugeValue,
+					float64(peer.LastHandshake.Unix()), conn.Name, peer.PublicKey)
+			}
+			ch <- prometheus.MustNewConstMetric(peerBytesDesc, prometheus.CounterValue,
+				float64(peer.BytesRx), conn.Name, peer.PublicKey, "rx")
+			ch <- prometheus.MustNewConstMetric(peerBytesDesc, prometheus.CounterValue,
+				float64(peer.BytesTx), conn.Name, peer.PublicKey, "tx")
+		}
+	}
+}
@@ -7,7 +7,11 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/tomventa/wirebalancer/internal/config"
 	"github.com/tomventa/wirebalancer/internal/stats"
 	"github.com/tomventa/wirebalancer/internal/wireguard"
 )
@@ -16,16 +20,35 @@ import (
 var templates embed.FS
 
 type Server struct {
-	port      int
-	stats     *stats.Tracker
-	wgManager *wireguard.Manager
+	port       int
+	stats      *stats.Tracker
+	wgManager  *wireguard.Manager
+	metrics    *metrics
+	adminToken string
 }
 
-func New(port int, statsTracker *stats.Tracker, wgManager *wireguard.Manager) *Server {
+func New(cfg *config.Config, statsTracker *stats.Tracker, wgManager *wireguard.Manager) *Server {
+	m := newMetrics(wgManager)
+
+	// Feed the Prometheus counter/histogram collectors from live tracker
+	// events so wirebalancer_requests_total and
+	// wirebalancer_healthcheck_latency_seconds reflect every request and
+	// health check, not just the latest snapshot.
+	statsTracker.SetObservers(
+		func(name string) {
+			m.requestsTotal.WithLabelValues(name).Inc()
+		},
+		func(name string, latency time.Duration) {
+			m.healthcheckLatency.WithLabelValues(name).Observe(latency.Seconds())
+		},
+	)
+
 	return &Server{
-		port:      port,
-		stats:     statsTracker,
-		wgManager: wgManager,
+		port:       cfg.WebServer.Port,
+		stats:      statsTracker,
+		wgManager:  wgManager,
+		metrics:    m,
+		adminToken: cfg.WebServer.AdminToken,
 	}
 }
 
@@ -33,6 +56,10 @@ func (s *Server) Start() error {
 	http.HandleFunc("/", s.handleDashboard)
 	http.HandleFunc("/api/stats", s.handleStatsAPI)
 	http.HandleFunc("/health", s.handleHealth)
+	http.Handle("/metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	http.HandleFunc("/api/admin/reload", s.requireAdmin(s.handleAdminReload))
+	http.HandleFunc("/api/admin/config", s.requireAdmin(s.handleAdminConfig))
+	http.HandleFunc("/api/suggest", s.handleSuggest)
 
 	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
 	log.Printf("Starting web server on %s", addr)
@@ -59,6 +86,45 @@ func (s *Server) handleStatsAPI(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// suggestResponse is the JSON body returned by /api/suggest.
+type suggestResponse struct {
+	Index     int     `json:"index"`
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+	Reason    string  `json:"reason"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+func (s *Server) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	conn, reason, err := s.wgManager.SuggestConnection()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	index := -1
+	for i, c := range s.wgManager.GetConnections() {
+		if c.Name == conn.Name {
+			index = i
+			break
+		}
+	}
+
+	resp := suggestResponse{
+		Index:     index,
+		Name:      conn.Name,
+		Score:     s.wgManager.Score(conn),
+		Reason:    string(reason),
+		LatencyMs: float64(s.stats.GetAverageLatency(conn.Name).Milliseconds()),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -71,8 +137,8 @@ func (s *Server) collectStats() stats.Stats {
 
 	data.Connections = make([]stats.ConnectionStat, len(connections))
 	for i, conn := range connections {
-		avgLatency := s.stats.GetAverageLatency(i)
-		lastCheck := s.stats.GetLastCheckTime(i)
+		avgLatency := s.stats.GetAverageLatency(conn.Name)
+		lastCheck := s.stats.GetLastCheckTime(conn.Name)
 		lastCheckStr := "Never"
 		if !lastCheck.IsZero() {
 			lastCheckStr = lastCheck.Format("15:04:05")
@@ -82,12 +148,38 @@ func (s *Server) collectStats() stats.Stats {
 			Index:          i,
 			Name:           conn.Name,
 			Healthy:        conn.IsHealthy(),
-			RequestCount:   s.stats.GetConnectionRequests(i),
+			RequestCount:   s.stats.GetConnectionRequests(conn.Name),
 			AverageLatency: avgLatency.String(),
 			LatencyMs:      float64(avgLatency.Milliseconds()),
 			LastCheck:      lastCheckStr,
+			Peers:          peerStats(conn.PeerStats()),
 		}
 	}
 
 	return data
 }
+
+// peerStats converts wireguard's internal peer telemetry into the
+// JSON-serializable shape used by the stats API and dashboard.
+func peerStats(peers []wireguard.PeerStat) []stats.PeerStat {
+	out := make([]stats.PeerStat, len(peers))
+	for i, p := range peers {
+		lastHandshake := "Never"
+		if !p.LastHandshake.IsZero() {
+			lastHandshake = p.LastHandshake.Format("15:04:05")
+		}
+
+		out[i] = stats.PeerStat{
+			PublicKey:           p.PublicKey,
+			Endpoint:            p.Endpoint,
+			AllowedIPs:          p.AllowedIPs,
+			LastHandshake:       lastHandshake,
+			BytesRx:             p.BytesRx,
+			BytesTx:             p.BytesTx,
+			PersistentKeepalive: p.PersistentKeepalive,
+			RxBps:               p.RxBps,
+			TxBps:               p.TxBps,
+		}
+	}
+	return out
+}
@@ -2,13 +2,16 @@ package wireguard
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,48 +23,156 @@ import (
 )
 
 type Connection struct {
-	Index         int
-	Name          string
-	InterfaceName string
-	ConfigPath    string
-	healthy       atomic.Bool
-	failureCount  atomic.Int32
-	lastCheck     atomic.Int64 // unix timestamp
+	Index int
+	Name  string
+
+	// cfgMu guards interfaceName, configPath, weight, and address: the first
+	// three are set at creation and address by bringUpConnection, and all
+	// four can be rewritten in place by UpdateConnection while request
+	// goroutines are concurrently reading
+	// them (via InterfaceName/ConfigPath/Weight/Address) to dial through
+	// this same connection, so plain field access isn't safe.
+	cfgMu         sync.RWMutex
+	interfaceName string
+	configPath    string
+	weight        int // relative weight used by the "weighted" selection policy
+	// address is the interface's primary assigned IP (no CIDR suffix), set
+	// once bringUpConnection applies the config's addresses. It's the local
+	// address the SOCKS5 proxy's BIND command listens on, so a peer dialing
+	// back in arrives through this connection's WireGuard tunnel rather than
+	// the host's default route. Empty until the connection has been brought
+	// up at least once.
+	address string
+
+	healthy      atomic.Bool
+	failureCount atomic.Int32
+	lastCheck    atomic.Int64 // unix timestamp
+
+	passiveMu       sync.Mutex
+	passiveFailures []time.Time // recent dial failures reported by the proxy, within passiveFailureWindow
+
+	peerMu         sync.Mutex
+	peers          []PeerStat
+	peerSampleTime time.Time
 }
 
+// PeerStat is the per-peer telemetry parsed from `wg show <iface> dump` for
+// a single WireGuard connection.
+type PeerStat struct {
+	PublicKey           string
+	Endpoint            string
+	AllowedIPs          string
+	LastHandshake       time.Time
+	BytesRx             int64
+	BytesTx             int64
+	PersistentKeepalive int // seconds, 0 if disabled
+	RxBps               float64
+	TxBps               float64
+}
+
+// Manager keeps connections in a name-keyed map (plus an insertion-order
+// index) rather than a fixed-size slice so connections can be added and
+// removed at runtime via AddConnection/RemoveConnection without reindexing.
 type Manager struct {
-	connections      []*Connection
-	cfg              config.WireGuardConfig
-	stats            *stats.Tracker
-	healthCheckURL   string
-	checkInterval    time.Duration
-	failureThreshold int
+	mu                   sync.RWMutex
+	connections          map[string]*Connection
+	order                []string // insertion order of connection names
+	nextIndex            int
+	cfg                  config.WireGuardConfig
+	stats                *stats.Tracker
+	healthCheckURL       string
+	checkInterval        time.Duration
+	failureThreshold     int
+	passiveFailures      int
+	passiveFailureWindow time.Duration
 }
 
 func NewManager(cfg config.WireGuardConfig, statsTracker *stats.Tracker) *Manager {
 	m := &Manager{
-		connections:      make([]*Connection, len(cfg.Connections)),
-		cfg:              cfg,
-		stats:            statsTracker,
-		healthCheckURL:   cfg.HealthCheckURL,
-		checkInterval:    time.Duration(cfg.HealthCheckInterval) * time.Second,
-		failureThreshold: cfg.FailureThreshold,
-	}
-
-	for i, connCfg := range cfg.Connections {
-		conn := &Connection{
-			Index:         i,
-			Name:          connCfg.Name,
-			InterfaceName: connCfg.InterfaceName,
-			ConfigPath:    connCfg.ConfigPath,
-		}
-		conn.healthy.Store(false)
-		m.connections[i] = conn
+		connections:          make(map[string]*Connection, len(cfg.Connections)),
+		cfg:                  cfg,
+		stats:                statsTracker,
+		healthCheckURL:       cfg.HealthCheckURL,
+		checkInterval:        time.Duration(cfg.HealthCheckInterval) * time.Second,
+		failureThreshold:     cfg.FailureThreshold,
+		passiveFailures:      cfg.PassiveFailures,
+		passiveFailureWindow: time.Duration(cfg.PassiveFailureWindow) * time.Second,
+	}
+
+	for _, connCfg := range cfg.Connections {
+		m.newConnection(connCfg)
 	}
 
 	return m
 }
 
+// newConnection registers connCfg as a tracked connection and returns it.
+// Callers must hold m.mu if called after construction.
+func (m *Manager) newConnection(connCfg config.ConnectionConfig) *Connection {
+	conn := &Connection{
+		Index:         m.nextIndex,
+		Name:          connCfg.Name,
+		interfaceName: connCfg.InterfaceName,
+		configPath:    connCfg.ConfigPath,
+		weight:        connCfg.Weight,
+	}
+	conn.healthy.Store(false)
+	m.nextIndex++
+	m.connections[connCfg.Name] = conn
+	m.order = append(m.order, connCfg.Name)
+	return conn
+}
+
+// InterfaceName returns the WireGuard interface this connection currently
+// dials/binds through.
+func (c *Connection) InterfaceName() string {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+	return c.interfaceName
+}
+
+// ConfigPath returns the wg-quick config file this connection was last
+// brought up from.
+func (c *Connection) ConfigPath() string {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+	return c.configPath
+}
+
+// Weight returns the relative weight used by the "weighted" selection
+// policy.
+func (c *Connection) Weight() int {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+	return c.weight
+}
+
+// Address returns the interface's primary assigned IP (no CIDR suffix), or
+// "" if the connection has never been brought up.
+func (c *Connection) Address() string {
+	c.cfgMu.RLock()
+	defer c.cfgMu.RUnlock()
+	return c.address
+}
+
+// setConfig replaces the interface name, config path, and weight in place,
+// as UpdateConnection does for a hot-reloaded connection.
+func (c *Connection) setConfig(interfaceName, configPath string, weight int) {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	c.interfaceName = interfaceName
+	c.configPath = configPath
+	c.weight = weight
+}
+
+// setAddress records the interface's primary assigned IP once
+// bringUpConnection applies the config's addresses.
+func (c *Connection) setAddress(address string) {
+	c.cfgMu.Lock()
+	defer c.cfgMu.Unlock()
+	c.address = address
+}
+
 func (m *Manager) Initialize() error {
 	log.Println("Initializing WireGuard connections...")
 
@@ -97,10 +208,12 @@ func (m *Manager) Initialize() error {
 }
 
 func (m *Manager) bringUpConnection(conn *Connection) error {
-	log.Printf("Bringing up WireGuard connection: %s (%s)", conn.Name, conn.InterfaceName)
+	interfaceName := conn.InterfaceName()
+	configPath := conn.ConfigPath()
+	log.Printf("Bringing up WireGuard connection: %s (%s)", conn.Name, interfaceName)
 
 	// Check if config file exists and has correct permissions
-	info, err := os.Stat(conn.ConfigPath)
+	info, err := os.Stat(configPath)
 	if err != nil {
 		return fmt.Errorf("config file error: %w", err)
 	}
@@ -108,25 +221,25 @@ func (m *Manager) bringUpConnection(conn *Connection) error {
 	// Check permissions - warn if too permissive but continue
 	if info.Mode().Perm() != 0600 {
 		log.Printf("Warning: %s has permissions %o, should be 0600. Attempting to fix...",
-			conn.ConfigPath, info.Mode().Perm())
-		if err := os.Chmod(conn.ConfigPath, 0600); err != nil {
+			configPath, info.Mode().Perm())
+		if err := os.Chmod(configPath, 0600); err != nil {
 			log.Printf("Warning: Could not fix permissions: %v", err)
 		}
 	}
 
 	// First, try to bring down if it exists
-	downCmd := exec.Command("ip", "link", "del", conn.InterfaceName)
+	downCmd := exec.Command("ip", "link", "del", interfaceName)
 	downCmd.Run() // Ignore errors, interface might not exist
 
 	// Create the WireGuard interface
-	cmd := exec.Command("ip", "link", "add", "dev", conn.InterfaceName, "type", "wireguard")
+	cmd := exec.Command("ip", "link", "add", "dev", interfaceName, "type", "wireguard")
 	if output, err := cmd.CombinedOutput(); err != nil {
 		// Interface might already exist, try to continue
 		log.Printf("Note: %s", string(output))
 	}
 
 	// Parse the config to strip out non-WireGuard settings and get addresses
-	wgConfig, addresses, err := m.parseWireGuardConfig(conn.ConfigPath)
+	wgConfig, addresses, err := m.parseWireGuardConfig(configPath)
 	if err != nil {
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
@@ -145,28 +258,29 @@ func (m *Manager) bringUpConnection(conn *Connection) error {
 	tmpFile.Close()
 
 	// Apply WireGuard configuration using wg setconf with stripped config
-	cmd = exec.Command("wg", "setconf", conn.InterfaceName, tmpFile.Name())
+	cmd = exec.Command("wg", "setconf", interfaceName, tmpFile.Name())
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to set wireguard config: %w (output: %s)", err, output)
 	}
 
 	// Set all IP addresses (both IPv4 and IPv6)
 	for _, address := range addresses {
-		cmd = exec.Command("ip", "address", "add", address, "dev", conn.InterfaceName)
+		cmd = exec.Command("ip", "address", "add", address, "dev", interfaceName)
 		if output, err := cmd.CombinedOutput(); err != nil {
 			// Address might already exist, just log warning
 			log.Printf("Warning adding address %s: %s", address, string(output))
 		}
 	}
+	conn.setAddress(primaryAddress(addresses))
 
 	// Bring the interface up
-	cmd = exec.Command("ip", "link", "set", "up", "dev", conn.InterfaceName)
+	cmd = exec.Command("ip", "link", "set", "up", "dev", interfaceName)
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to bring up interface: %w (output: %s)", err, output)
 	}
 
 	// Set MTU if needed
-	cmd = exec.Command("ip", "link", "set", "dev", conn.InterfaceName, "mtu", "1420")
+	cmd = exec.Command("ip", "link", "set", "dev", interfaceName, "mtu", "1420")
 	cmd.Run() // Ignore errors
 
 	// CRITICAL: We do NOT add default routes here
@@ -177,6 +291,21 @@ func (m *Manager) bringUpConnection(conn *Connection) error {
 	return nil
 }
 
+// primaryAddress returns addresses[0] with its CIDR suffix stripped, for use
+// as a connection's Address. It returns "" if addresses is empty or its
+// first entry isn't a valid CIDR, since BIND simply won't be usable on that
+// connection in that case.
+func primaryAddress(addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	ip, _, err := net.ParseCIDR(addresses[0])
+	if err != nil {
+		return ""
+	}
+	return ip.String()
+}
+
 func (m *Manager) parseWireGuardConfig(configPath string) (string, []string, error) {
 	data, err := os.ReadFile(configPath)
 	if err != nil {
@@ -292,9 +421,14 @@ func (m *Manager) StartHealthChecks(ctx context.Context) {
 
 func (m *Manager) performHealthCheck(conn *Connection) {
 	err := m.checkHealth(conn)
+	m.updatePeerStats(conn)
 	now := time.Now().Unix()
 	conn.lastCheck.Store(now)
 
+	if err == nil && conn.HandshakeStale() {
+		err = fmt.Errorf("wireguard handshake is stale")
+	}
+
 	if err != nil {
 		failures := conn.failureCount.Add(1)
 		log.Printf("Health check failed for %s (failures: %d): %v", conn.Name, failures, err)
@@ -303,7 +437,7 @@ func (m *Manager) performHealthCheck(conn *Connection) {
 			if conn.healthy.Load() {
 				log.Printf("Marking connection %s as unhealthy", conn.Name)
 				conn.healthy.Store(false)
-				m.stats.SetConnectionHealth(conn.Index, false)
+				m.stats.SetConnectionHealth(conn.Name, false)
 			}
 		}
 	} else {
@@ -311,7 +445,7 @@ func (m *Manager) performHealthCheck(conn *Connection) {
 		if !conn.healthy.Load() {
 			log.Printf("Connection %s is now healthy", conn.Name)
 			conn.healthy.Store(true)
-			m.stats.SetConnectionHealth(conn.Index, true)
+			m.stats.SetConnectionHealth(conn.Name, true)
 		}
 	}
 }
@@ -329,7 +463,7 @@ func (m *Manager) checkHealth(conn *Connection) error {
 		Control: func(network, address string, c syscall.RawConn) error {
 			var operr error
 			err := c.Control(func(fd uintptr) {
-				operr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, conn.InterfaceName)
+				operr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, conn.InterfaceName())
 			})
 			if err != nil {
 				return err
@@ -373,16 +507,166 @@ func (m *Manager) checkHealth(conn *Connection) error {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	m.stats.RecordLatency(conn.Index, latency)
+	m.stats.RecordLatency(conn.Name, latency)
 	return nil
 }
 
+// updatePeerStats parses `wg show <iface> dump` for conn and stores the
+// resulting per-peer telemetry, computing throughput deltas against the
+// previous sample.
+func (m *Manager) updatePeerStats(conn *Connection) {
+	peers, err := parseWGDump(conn.InterfaceName())
+	if err != nil {
+		log.Printf("Failed to read wg dump for %s: %v", conn.Name, err)
+		return
+	}
+	conn.setPeerStats(peers)
+}
+
+// parseWGDump runs `wg show <interfaceName> dump` and parses its
+// tab-separated output. The first line describes the interface itself and is
+// skipped; each remaining line describes one peer: public-key, preshared-key,
+// endpoint, allowed-ips, latest-handshake, transfer-rx, transfer-tx,
+// persistent-keepalive.
+func parseWGDump(interfaceName string) ([]PeerStat, error) {
+	output, err := exec.Command("wg", "show", interfaceName, "dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("wg show dump: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) <= 1 {
+		return nil, nil
+	}
+
+	peers := make([]PeerStat, 0, len(lines)-1)
+	for _, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 8 {
+			continue
+		}
+
+		var lastHandshake time.Time
+		if ts, err := strconv.ParseInt(fields[4], 10, 64); err == nil && ts > 0 {
+			lastHandshake = time.Unix(ts, 0)
+		}
+
+		rx, _ := strconv.ParseInt(fields[5], 10, 64)
+		tx, _ := strconv.ParseInt(fields[6], 10, 64)
+
+		keepalive := 0
+		if fields[7] != "off" {
+			if v, err := strconv.Atoi(fields[7]); err == nil {
+				keepalive = v
+			}
+		}
+
+		peers = append(peers, PeerStat{
+			PublicKey:           fields[0],
+			Endpoint:            fields[2],
+			AllowedIPs:          fields[3],
+			LastHandshake:       lastHandshake,
+			BytesRx:             rx,
+			BytesTx:             tx,
+			PersistentKeepalive: keepalive,
+		})
+	}
+
+	return peers, nil
+}
+
+func (c *Connection) setPeerStats(next []PeerStat) {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(c.peerSampleTime).Seconds(); elapsed > 0 && !c.peerSampleTime.IsZero() {
+		prev := make(map[string]PeerStat, len(c.peers))
+		for _, p := range c.peers {
+			prev[p.PublicKey] = p
+		}
+		for i := range next {
+			old, ok := prev[next[i].PublicKey]
+			if !ok || next[i].BytesRx < old.BytesRx || next[i].BytesTx < old.BytesTx {
+				continue
+			}
+			next[i].RxBps = float64(next[i].BytesRx-old.BytesRx) / elapsed
+			next[i].TxBps = float64(next[i].BytesTx-old.BytesTx) / elapsed
+		}
+	}
+
+	c.peers = next
+	c.peerSampleTime = now
+}
+
+// PeerStats returns a snapshot of the connection's most recently sampled
+// per-peer telemetry.
+func (c *Connection) PeerStats() []PeerStat {
+	c.peerMu.Lock()
+	defer c.peerMu.Unlock()
+	peers := make([]PeerStat, len(c.peers))
+	copy(peers, c.peers)
+	return peers
+}
+
+// HandshakeStale reports whether any known peer's handshake is old enough
+// that the tunnel should be considered unhealthy even if the active HTTP
+// probe succeeds: more than 3x the persistent keepalive interval, or 180s
+// when no keepalive is configured.
+func (c *Connection) HandshakeStale() bool {
+	for _, p := range c.PeerStats() {
+		if p.LastHandshake.IsZero() {
+			return true
+		}
+
+		threshold := 180 * time.Second
+		if p.PersistentKeepalive > 0 {
+			threshold = 3 * time.Duration(p.PersistentKeepalive) * time.Second
+		}
+		if time.Since(p.LastHandshake) > threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// handshakeFreshnessScore returns a [0, 1] score for the most recent peer
+// handshake, 1 being just-happened and 0 being stale or unknown (see
+// HandshakeStale for the staleness threshold).
+func (c *Connection) handshakeFreshnessScore() float64 {
+	var freshest time.Time
+	var keepalive int
+	for _, p := range c.PeerStats() {
+		if p.LastHandshake.After(freshest) {
+			freshest = p.LastHandshake
+			keepalive = p.PersistentKeepalive
+		}
+	}
+	if freshest.IsZero() {
+		return 0
+	}
+
+	threshold := 180 * time.Second
+	if keepalive > 0 {
+		threshold = 3 * time.Duration(keepalive) * time.Second
+	}
+	age := time.Since(freshest)
+	if age >= threshold {
+		return 0
+	}
+	return 1 - float64(age)/float64(threshold)
+}
+
+// GetHealthyConnection returns the healthy connection whose Index is index —
+// its stable, creation-order identity (for connections present at startup,
+// its 0-based position in the config file), not a position into
+// GetConnections()'s current order, which a later AddConnection/
+// RemoveConnection can reshuffle.
 func (m *Manager) GetHealthyConnection(index int) (*Connection, error) {
-	if index < 0 || index >= len(m.connections) {
+	conn, ok := m.getConnectionByIndex(index)
+	if !ok {
 		return nil, fmt.Errorf("invalid connection index: %d", index)
 	}
-
-	conn := m.connections[index]
 	if !conn.healthy.Load() {
 		return nil, fmt.Errorf("connection %s is not healthy", conn.Name)
 	}
@@ -390,26 +674,317 @@ func (m *Manager) GetHealthyConnection(index int) (*Connection, error) {
 	return conn, nil
 }
 
-func (m *Manager) GetRandomHealthyConnection() (*Connection, error) {
-	healthyConns := make([]*Connection, 0, len(m.connections))
+// GetConnections returns all tracked connections in stable insertion order.
+func (m *Manager) GetConnections() []*Connection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	conns := make([]*Connection, 0, len(m.order))
+	for _, name := range m.order {
+		if conn, ok := m.connections[name]; ok {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+func (m *Manager) getConnection(name string) (*Connection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	conn, ok := m.connections[name]
+	return conn, ok
+}
 
+// getConnectionByIndex returns the tracked connection whose immutable Index
+// field matches index, regardless of its current position in m.order — a
+// position that AddConnection/RemoveConnection can change, unlike Index.
+func (m *Manager) getConnectionByIndex(index int) (*Connection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	for _, conn := range m.connections {
+		if conn.Index == index {
+			return conn, true
+		}
+	}
+	return nil, false
+}
+
+// GetConnectionByName returns the named connection if it exists and is
+// currently healthy, for callers (e.g. per-user connection pinning) that
+// need a specific tunnel rather than one picked by policy.
+func (m *Manager) GetConnectionByName(name string) (*Connection, error) {
+	conn, ok := m.getConnection(name)
+	if !ok {
+		return nil, fmt.Errorf("connection %s does not exist", name)
+	}
+	if !conn.healthy.Load() {
+		return nil, fmt.Errorf("connection %s is not healthy", name)
+	}
+	return conn, nil
+}
+
+// AddConnection brings up a new WireGuard connection described by connCfg
+// and starts tracking it. It is the hot-reload counterpart to the
+// connections brought up at startup by Initialize.
+func (m *Manager) AddConnection(connCfg config.ConnectionConfig) error {
+	m.mu.Lock()
+	if _, exists := m.connections[connCfg.Name]; exists {
+		m.mu.Unlock()
+		return fmt.Errorf("connection %s already exists", connCfg.Name)
+	}
+	conn := m.newConnection(connCfg)
+	m.mu.Unlock()
+
+	m.stats.AddConnection(conn.Name)
+
+	if err := m.bringUpConnection(conn); err != nil {
+		return fmt.Errorf("bringing up connection %s: %w", conn.Name, err)
+	}
+	if err := m.checkHealth(conn); err != nil {
+		log.Printf("Initial health check failed for %s: %v", conn.Name, err)
+	}
+	return nil
+}
+
+// RemoveConnection tears down and stops tracking the named WireGuard
+// connection.
+func (m *Manager) RemoveConnection(name string) error {
+	m.mu.Lock()
+	conn, exists := m.connections[name]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("connection %s does not exist", name)
+	}
+	delete(m.connections, name)
+	for i, n := range m.order {
+		if n == name {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	cmd := exec.Command("ip", "link", "del", conn.InterfaceName())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Error removing %s: %v (output: %s)", conn.InterfaceName(), err, output)
+	}
+
+	m.stats.RemoveConnection(name)
+	return nil
+}
+
+// UpdateConnection replaces the interface/config/weight of the existing
+// connection named connCfg.Name, tearing down its current tunnel and
+// bringing up the new one in its place. Stats continue under the same name.
+func (m *Manager) UpdateConnection(connCfg config.ConnectionConfig) error {
+	conn, exists := m.getConnection(connCfg.Name)
+	if !exists {
+		return fmt.Errorf("connection %s does not exist", connCfg.Name)
+	}
+
+	downCmd := exec.Command("ip", "link", "del", conn.InterfaceName())
+	downCmd.Run() // Ignore errors, interface might already be down
+
+	conn.setConfig(connCfg.InterfaceName, connCfg.ConfigPath, connCfg.Weight)
+	conn.healthy.Store(false)
+	m.stats.SetConnectionHealth(conn.Name, false)
+
+	if err := m.bringUpConnection(conn); err != nil {
+		return fmt.Errorf("bringing up connection %s: %w", conn.Name, err)
+	}
+	if err := m.checkHealth(conn); err != nil {
+		log.Printf("Health check failed for %s after update: %v", conn.Name, err)
+	}
+	return nil
+}
+
+// RunningConfig returns the live WireGuardConfig, reflecting any connections
+// added, removed, or updated via the admin hot-reload API since startup.
+func (m *Manager) RunningConfig() config.WireGuardConfig {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cfg := m.cfg
+	cfg.Connections = make([]config.ConnectionConfig, 0, len(m.order))
+	for _, name := range m.order {
+		conn := m.connections[name]
+		cfg.Connections = append(cfg.Connections, config.ConnectionConfig{
+			Name:          conn.Name,
+			InterfaceName: conn.InterfaceName(),
+			ConfigPath:    conn.ConfigPath(),
+			Weight:        conn.Weight(),
+		})
+	}
+	return cfg
+}
+
+// Reason explains why SuggestConnection picked the connection it did.
+type Reason string
+
+const (
+	// ReasonScored means the connection won on composite score.
+	ReasonScored Reason = "scored"
+	// ReasonColdStart means no connection has recorded a latency sample or
+	// peer handshake yet, so one was chosen at random among healthy
+	// connections instead of ranking scores that no signal has fed yet.
+	ReasonColdStart Reason = "cold_start"
+)
+
+// Score returns conn's composite suitability score in [0, 1]: 60% average
+// latency from health checks and proxy dials (lower is better, capped at
+// 2s), 30% recent passive dial failure rate, and 10% peer handshake
+// freshness. Higher is better.
+func (m *Manager) Score(conn *Connection) float64 {
+	latency := m.stats.GetAverageLatency(conn.Name)
+	latencyScore := 1.0
+	if latency > 0 {
+		const latencyCap = 2 * time.Second
+		ratio := float64(latency) / float64(latencyCap)
+		if ratio > 1 {
+			ratio = 1
+		}
+		latencyScore = 1 - ratio
+	}
+
+	failureRatio := 0.0
+	if m.passiveFailures > 0 {
+		failureRatio = float64(conn.passiveFailureCount(m.passiveFailureWindow)) / float64(m.passiveFailures)
+		if failureRatio > 1 {
+			failureRatio = 1
+		}
+	}
+	failureScore := 1 - failureRatio
+
+	return 0.6*latencyScore + 0.3*failureScore + 0.1*conn.handshakeFreshnessScore()
+}
+
+// SuggestConnection ranks the healthy connections by Score and returns the
+// best one, breaking ties by lowest request count to spread load across
+// equally-good tunnels. If no healthy connection has recorded a latency
+// sample or a peer handshake yet (cold start), it instead returns a random
+// healthy connection with ReasonColdStart.
+func (m *Manager) SuggestConnection() (*Connection, Reason, error) {
+	var healthy []*Connection
+	for _, conn := range m.GetConnections() {
 		if conn.healthy.Load() {
-			healthyConns = append(healthyConns, conn)
+			healthy = append(healthy, conn)
 		}
 	}
+	if len(healthy) == 0 {
+		return nil, "", fmt.Errorf("no healthy connections available")
+	}
 
-	if len(healthyConns) == 0 {
-		return nil, fmt.Errorf("no healthy connections available")
+	scored := false
+	for _, conn := range healthy {
+		if m.stats.GetAverageLatency(conn.Name) > 0 {
+			scored = true
+			break
+		}
+		for _, p := range conn.PeerStats() {
+			if !p.LastHandshake.IsZero() {
+				scored = true
+				break
+			}
+		}
+		if scored {
+			break
+		}
+	}
+	if !scored {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(healthy))))
+		if err != nil {
+			return nil, "", fmt.Errorf("choosing cold-start connection: %w", err)
+		}
+		return healthy[n.Int64()], ReasonColdStart, nil
 	}
 
-	// Simple round-robin for now (can be improved with actual randomness)
-	idx := int(time.Now().UnixNano() % int64(len(healthyConns)))
-	return healthyConns[idx], nil
+	best := healthy[0]
+	bestScore := m.Score(best)
+	bestRequests := m.stats.GetConnectionRequests(best.Name)
+	for _, conn := range healthy[1:] {
+		score := m.Score(conn)
+		requests := m.stats.GetConnectionRequests(conn.Name)
+		if score > bestScore || (score == bestScore && requests < bestRequests) {
+			best, bestScore, bestRequests = conn, score, requests
+		}
+	}
+	return best, ReasonScored, nil
 }
 
-func (m *Manager) GetConnections() []*Connection {
-	return m.connections
+// ReportDialFailure records a failed upstream dial through the connection
+// whose Index is connIndex, as observed by the proxy. If the sliding window
+// of recent passive failures reaches the configured threshold, the
+// connection is marked unhealthy immediately and an out-of-band active
+// recheck is scheduled rather than waiting for the next health check tick.
+func (m *Manager) ReportDialFailure(connIndex int, err error) {
+	conn, ok := m.getConnectionByIndex(connIndex)
+	if !ok {
+		return
+	}
+	conn.recordPassiveFailure()
+
+	if conn.passiveFailureCount(m.passiveFailureWindow) < m.passiveFailures {
+		return
+	}
+
+	if conn.healthy.Load() {
+		log.Printf("Marking connection %s as unhealthy (passive dial failures): %v", conn.Name, err)
+		conn.healthy.Store(false)
+		m.stats.SetConnectionHealth(conn.Name, false)
+	}
+	go m.performHealthCheck(conn)
+}
+
+// ReportDialSuccess records a successful upstream dial through the
+// connection whose Index is connIndex, decaying its passive failure window
+// and recording the dial latency. If the connection is unhealthy and its
+// passive failure window is empty, it is re-marked healthy immediately,
+// whatever made it unhealthy.
+func (m *Manager) ReportDialSuccess(connIndex int, latency time.Duration) {
+	conn, ok := m.getConnectionByIndex(connIndex)
+	if !ok {
+		return
+	}
+	conn.decayPassiveFailures()
+	m.stats.RecordLatency(conn.Name, latency)
+
+	if !conn.healthy.Load() && conn.passiveFailureCount(m.passiveFailureWindow) == 0 {
+		log.Printf("Connection %s is now healthy (passive dial success)", conn.Name)
+		conn.failureCount.Store(0)
+		conn.healthy.Store(true)
+		m.stats.SetConnectionHealth(conn.Name, true)
+	}
+}
+
+func (c *Connection) recordPassiveFailure() {
+	c.passiveMu.Lock()
+	defer c.passiveMu.Unlock()
+	c.passiveFailures = append(c.passiveFailures, time.Now())
+}
+
+func (c *Connection) decayPassiveFailures() {
+	c.passiveMu.Lock()
+	defer c.passiveMu.Unlock()
+	if len(c.passiveFailures) > 0 {
+		c.passiveFailures = c.passiveFailures[1:]
+	}
+}
+
+// passiveFailureCount prunes failures older than window and returns the
+// number that remain.
+func (c *Connection) passiveFailureCount(window time.Duration) int {
+	c.passiveMu.Lock()
+	defer c.passiveMu.Unlock()
+
+	cutoff := time.Now().Add(-window)
+	i := 0
+	for i < len(c.passiveFailures) && c.passiveFailures[i].Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		c.passiveFailures = c.passiveFailures[i:]
+	}
+	return len(c.passiveFailures)
 }
 
 func (m *Manager) Cleanup() {
@@ -423,9 +998,9 @@ func (m *Manager) Cleanup() {
 			log.Printf("Bringing down connection: %s", c.Name)
 
 			// Remove the interface
-			cmd := exec.Command("ip", "link", "del", c.InterfaceName)
+			cmd := exec.Command("ip", "link", "del", c.InterfaceName())
 			if output, err := cmd.CombinedOutput(); err != nil {
-				log.Printf("Error removing %s: %v (output: %s)", c.InterfaceName, err, output)
+				log.Printf("Error removing %s: %v (output: %s)", c.InterfaceName(), err, output)
 			}
 		}(conn)
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"flag"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/tomventa/wirebalancer/internal/config"
 	"github.com/tomventa/wirebalancer/internal/proxy"
+	"github.com/tomventa/wirebalancer/internal/selection"
 	"github.com/tomventa/wirebalancer/internal/stats"
 	"github.com/tomventa/wirebalancer/internal/webserver"
 	"github.com/tomventa/wirebalancer/internal/wireguard"
@@ -28,7 +30,11 @@ func main() {
 	}
 
 	// Initialize stats tracker
-	statsTracker := stats.NewTracker(len(cfg.WireGuard.Connections))
+	connNames := make([]string, len(cfg.WireGuard.Connections))
+	for i, connCfg := range cfg.WireGuard.Connections {
+		connNames[i] = connCfg.Name
+	}
+	statsTracker := stats.NewTracker(connNames)
 
 	// Initialize WireGuard manager
 	wgManager := wireguard.NewManager(cfg.WireGuard, statsTracker)
@@ -44,23 +50,35 @@ func main() {
 	go wgManager.StartHealthChecks(ctx)
 
 	// Initialize proxy manager
-	proxyManager := proxy.NewManager(cfg.Proxy, wgManager, statsTracker)
+	proxyManager, err := proxy.NewManager(cfg.Proxy, wgManager, statsTracker)
+	if err != nil {
+		log.Fatalf("Failed to initialize proxy manager: %v", err)
+	}
+
+	listeners := cfg.Proxy.Listeners
+	if len(listeners) == 0 {
+		listeners = defaultListeners(cfg)
+	}
 
-	// Start SOCKS5 proxies
+	// Start SOCKS5 proxies, one per configured listener
 	var wg sync.WaitGroup
-	for i := 0; i < len(cfg.WireGuard.Connections)+1; i++ {
+	for _, lc := range listeners {
 		wg.Add(1)
-		go func(index int) {
+		go func(lc config.ListenerConfig) {
 			defer wg.Done()
-			port := cfg.Proxy.BasePort + index
-			if err := proxyManager.StartProxy(ctx, index, port); err != nil {
-				log.Printf("Failed to start proxy on port %d: %v", port, err)
+			selector, err := newSelector(lc, wgManager, statsTracker)
+			if err != nil {
+				log.Printf("Failed to configure listener on port %d: %v", lc.Port, err)
+				return
 			}
-		}(i)
+			if err := proxyManager.StartProxy(ctx, lc.Port, selector); err != nil {
+				log.Printf("Failed to start proxy on port %d: %v", lc.Port, err)
+			}
+		}(lc)
 	}
 
 	// Start web server for stats
-	webServer := webserver.New(cfg.WebServer.Port, statsTracker, wgManager)
+	webServer := webserver.New(cfg, statsTracker, wgManager)
 	go func() {
 		if err := webServer.Start(); err != nil {
 			log.Printf("Web server error: %v", err)
@@ -68,9 +86,12 @@ func main() {
 	}()
 
 	log.Printf("WireBalancer started successfully")
-	log.Printf("Random proxy: localhost:%d", cfg.Proxy.BasePort)
-	for i := 0; i < len(cfg.WireGuard.Connections); i++ {
-		log.Printf("Connection %d proxy: localhost:%d", i, cfg.Proxy.BasePort+i+1)
+	for _, lc := range listeners {
+		if lc.PinIndex > 0 {
+			log.Printf("Connection %d proxy: localhost:%d", lc.PinIndex-1, lc.Port)
+		} else {
+			log.Printf("%s proxy: localhost:%d", policyLabel(lc.Policy), lc.Port)
+		}
 	}
 	log.Printf("Stats dashboard: http://localhost:%d", cfg.WebServer.Port)
 
@@ -99,3 +120,50 @@ func main() {
 	wgManager.Cleanup()
 	log.Println("Shutdown complete")
 }
+
+// defaultListeners reproduces the historical layout when no listeners are
+// configured explicitly: a listener on BasePort using DefaultPolicy
+// ("random" if unset), plus one listener per WireGuard connection pinned to
+// that connection on BasePort+index+1.
+func defaultListeners(cfg *config.Config) []config.ListenerConfig {
+	listeners := make([]config.ListenerConfig, 0, len(cfg.WireGuard.Connections)+1)
+	listeners = append(listeners, config.ListenerConfig{
+		Port:   cfg.Proxy.BasePort,
+		Policy: policyLabel(cfg.Proxy.DefaultPolicy),
+	})
+	for i := range cfg.WireGuard.Connections {
+		listeners = append(listeners, config.ListenerConfig{
+			Port:     cfg.Proxy.BasePort + i + 1,
+			PinIndex: i + 1,
+		})
+	}
+	return listeners
+}
+
+func policyLabel(policy string) string {
+	if policy == "" {
+		return "random"
+	}
+	return policy
+}
+
+// newSelector builds the proxy.ConnectionSelector for a single listener,
+// either pinning it to a specific WireGuard connection or delegating to a
+// selection.Policy.
+func newSelector(lc config.ListenerConfig, wgManager *wireguard.Manager, statsTracker *stats.Tracker) (proxy.ConnectionSelector, error) {
+	if lc.PinIndex > 0 {
+		index := lc.PinIndex - 1
+		return func(clientAddr net.Addr) (*wireguard.Connection, error) {
+			return wgManager.GetHealthyConnection(index)
+		}, nil
+	}
+
+	policy, err := selection.New(lc.Policy, statsTracker, wgManager)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(clientAddr net.Addr) (*wireguard.Connection, error) {
+		return policy.Select(wgManager.GetConnections(), &selection.ProxyRequest{ClientAddr: clientAddr})
+	}, nil
+}